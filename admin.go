@@ -0,0 +1,977 @@
+package mgo
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// ServerStatus holds an overview of the state of a MongoDB server, as
+// reported by the serverStatus command. The full document returned by
+// the server is available in Raw.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/serverStatus/
+//
+type ServerStatus struct {
+	Host      string
+	Version   string
+	Process   string
+	Pid       int64
+	Uptime    time.Duration `bson:"-"`
+	LocalTime time.Time     `bson:"localTime"`
+
+	Connections struct {
+		Current      int
+		Available    int
+		TotalCreated int64 `bson:"totalCreated"`
+	}
+
+	Opcounters struct {
+		Insert  int64
+		Query   int64
+		Update  int64
+		Delete  int64
+		GetMore int64 `bson:"getmore"`
+		Command int64
+	}
+
+	Mem struct {
+		Bits     int
+		Resident int64 // In megabytes
+		Virtual  int64 // In megabytes
+	}
+
+	Network struct {
+		BytesIn     int64 `bson:"bytesIn"`
+		BytesOut    int64 `bson:"bytesOut"`
+		NumRequests int64 `bson:"numRequests"`
+	}
+
+	// Repl is only set when the server is a replica set member.
+	Repl *ServerStatusRepl
+
+	// StorageEngine holds the name of the storage engine in use,
+	// such as "wiredTiger". Servers prior to 3.0 always report
+	// "mmapv1".
+	StorageEngine string `bson:"-"`
+
+	Raw bson.M `bson:"-"`
+}
+
+// ServerStatusRepl holds the replication details within ServerStatus.
+type ServerStatusRepl struct {
+	SetName   string `bson:"setName"`
+	IsMaster  bool   `bson:"ismaster"`
+	Secondary bool
+	Primary   string
+	Me        string
+	Hosts     []string
+}
+
+// ServerStatus returns an overview of the state of the server the
+// session is talking to.
+func (s *Session) ServerStatus() (status *ServerStatus, err error) {
+	var raw bson.Raw
+	if err = s.Run(bson.D{{"serverStatus", 1}}, &raw); err != nil {
+		return nil, err
+	}
+	var extra struct {
+		UptimeMillis  int64   `bson:"uptimeMillis"`
+		Uptime        float64 `bson:"uptime"`
+		StorageEngine struct {
+			Name string
+		} `bson:"storageEngine"`
+	}
+	status = &ServerStatus{}
+	if err = raw.Unmarshal(status); err != nil {
+		return nil, err
+	}
+	if err = raw.Unmarshal(&extra); err != nil {
+		return nil, err
+	}
+	if err = raw.Unmarshal(&status.Raw); err != nil {
+		return nil, err
+	}
+	if extra.UptimeMillis > 0 {
+		status.Uptime = time.Duration(extra.UptimeMillis) * time.Millisecond
+	} else {
+		status.Uptime = time.Duration(extra.Uptime * float64(time.Second))
+	}
+	status.StorageEngine = extra.StorageEngine.Name
+	if status.StorageEngine == "" {
+		status.StorageEngine = "mmapv1"
+	}
+	return status, nil
+}
+
+// Operation holds the details of an operation in progress, as
+// reported by Session.CurrentOp. The full document reported by
+// the server is available in Raw.
+type Operation struct {
+	// OpId identifies the operation for Session.KillOp. It holds an
+	// int on mongod servers and a "shard:opid" string on mongos.
+	OpId interface{} `bson:"opid"`
+
+	Type         string
+	Active       bool
+	Op           string
+	Namespace    string `bson:"ns"`
+	Client       string
+	AppName      string `bson:"appName"`
+	Desc         string
+	ConnectionId int64  `bson:"connectionId"`
+	PlanSummary  string `bson:"planSummary"`
+
+	// Command holds the command or query being run. Servers
+	// prior to 3.2 report queries only.
+	Command bson.M
+
+	Running        time.Duration `bson:"-"`
+	WaitingForLock bool          `bson:"waitingForLock"`
+
+	Raw bson.M `bson:"-"`
+}
+
+// SetBSON implements bson.Setter.
+func (op *Operation) SetBSON(raw bson.Raw) error {
+	type operation Operation
+	var doc struct {
+		operation        `bson:",inline"`
+		Query            bson.M
+		SecsRunning      int64 `bson:"secs_running"`
+		MicrosecsRunning int64 `bson:"microsecs_running"`
+	}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+	*op = Operation(doc.operation)
+	if op.Command == nil {
+		op.Command = doc.Query
+	}
+	if doc.MicrosecsRunning > 0 {
+		op.Running = time.Duration(doc.MicrosecsRunning) * time.Microsecond
+	} else {
+		op.Running = time.Duration(doc.SecsRunning) * time.Second
+	}
+	return raw.Unmarshal(&op.Raw)
+}
+
+// CurrentOp returns the operations in progress in the server that
+// match filter, which may be nil. Beyond the Operation fields, filter
+// may hold the "$all" and "$ownOps" options understood by the server.
+//
+// For example:
+//
+//     ops, err := session.CurrentOp(bson.M{"active": true, "secs_running": bson.M{"$gt": 3}})
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/currentOp/
+//
+func (s *Session) CurrentOp(filter bson.M) ([]Operation, error) {
+	var result struct{ InProg []Operation }
+	cmd := bson.D{{"currentOp", 1}}
+	for name, value := range filter {
+		cmd = append(cmd, bson.DocElem{name, value})
+	}
+	err := s.Run(cmd, &result)
+	if isNoCmd(err) {
+		// Servers prior to 3.2 expose the operations via a query.
+		if filter == nil {
+			filter = bson.M{}
+		}
+		err = s.DB("admin").C("$cmd.sys.inprog").Find(filter).One(&result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.InProg, nil
+}
+
+// KillOp terminates the operation in progress identified by opid, which
+// must be the value of the OpId field reported by Session.CurrentOp.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/killOp/
+//
+func (s *Session) KillOp(opid interface{}) error {
+	err := s.Run(bson.D{{"killOp", 1}, {"op", opid}}, nil)
+	if isNoCmd(err) {
+		// Servers prior to 3.2 expose the operation via a query.
+		err = s.DB("admin").C("$cmd.sys.killop").Find(bson.M{"op": opid}).One(nil)
+	}
+	return err
+}
+
+// DBStats holds the storage statistics of a database, as reported by
+// Database.Stats. Sizes are in bytes divided by the requested scale.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/dbStats/
+//
+type DBStats struct {
+	DB          string
+	Collections int
+	Views       int // On MongoDB 3.4+
+	Objects     int64
+	AvgObjSize  float64 `bson:"avgObjSize"`
+	DataSize    int64   `bson:"dataSize"`
+	StorageSize int64   `bson:"storageSize"`
+	Indexes     int
+	IndexSize   int64 `bson:"indexSize"`
+	FsUsedSize  int64 `bson:"fsUsedSize"`  // On MongoDB 3.6+
+	FsTotalSize int64 `bson:"fsTotalSize"` // On MongoDB 3.6+
+	Scale       int
+}
+
+// Stats returns the storage statistics of the db database, with sizes
+// divided by scale. A scale of 1024 reports sizes in kilobytes, for
+// example. Scale defaults to 1 if zero.
+func (db *Database) Stats(scale int) (stats *DBStats, err error) {
+	if scale < 1 {
+		scale = 1
+	}
+	stats = &DBStats{}
+	err = db.Run(bson.D{{"dbStats", 1}, {"scale", scale}}, stats)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Scale == 0 {
+		stats.Scale = scale
+	}
+	return stats, nil
+}
+
+// CollectionStats holds the storage statistics of a collection, as
+// reported by Collection.Stats. Sizes are in bytes.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/collStats/
+//
+type CollectionStats struct {
+	Namespace      string `bson:"ns"`
+	Count          int64
+	Size           int64
+	AvgObjSize     float64 `bson:"avgObjSize"`
+	StorageSize    int64   `bson:"storageSize"`
+	Capped         bool
+	Max            int64
+	MaxSize        int64            `bson:"maxSize"`
+	NIndexes       int              `bson:"nindexes"`
+	TotalIndexSize int64            `bson:"totalIndexSize"`
+	IndexSizes     map[string]int64 `bson:"indexSizes"`
+
+	// IndexStats holds the usage statistics of each index, on
+	// MongoDB 3.2+ when allowed to obtain them. See IndexStats.
+	IndexStats []IndexStats `bson:"-"`
+}
+
+// IndexStats holds usage statistics of an index, as reported by
+// Collection.Stats and Collection.IndexStats.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/aggregation/indexStats/
+//
+type IndexStats struct {
+	Name     string
+	Key      bson.D
+	Host     string
+	Accesses struct {
+		Ops   int64
+		Since time.Time
+	}
+}
+
+// Stats returns the storage statistics of the c collection, including
+// the size and the usage statistics of each of its indexes. The usage
+// statistics are left out if the user lacks the indexStats privilege.
+func (c *Collection) Stats() (stats *CollectionStats, err error) {
+	stats = &CollectionStats{}
+	err = c.Database.Run(bson.D{{"collStats", c.Name}}, stats)
+	if err != nil {
+		return nil, err
+	}
+	stats.IndexStats, err = c.IndexStats()
+	if err != nil && !isAuthError(err) {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// IndexStats returns the usage statistics of each index of the c
+// collection, via the $indexStats aggregation stage, which requires
+// MongoDB 3.2+ and the indexStats privilege. On prior servers no
+// statistics are returned.
+func (c *Collection) IndexStats() (stats []IndexStats, err error) {
+	err = c.Pipe([]bson.M{{"$indexStats": bson.M{}}}).All(&stats)
+	if qerr, ok := err.(*QueryError); ok && (qerr.Code == 16436 || qerr.Code == 40324) {
+		// Unrecognized pipeline stage on servers prior to 3.2.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// runOnMember runs cmd against the admin database of the cluster member
+// with the provided address, which must be known to the session. If addr
+// is empty the command is run as done by Session.Run.
+func (s *Session) runOnMember(addr string, cmd, result interface{}) error {
+	if addr == "" {
+		return s.Run(cmd, result)
+	}
+	s.m.RLock()
+	syncTimeout := s.syncTimeout
+	sockTimeout := s.sockTimeout
+	s.m.RUnlock()
+
+	server, err := s.cluster().Server(addr, syncTimeout)
+	if err != nil {
+		return err
+	}
+	socket, _, err := server.AcquireSocket(0, sockTimeout)
+	if err != nil {
+		return err
+	}
+	defer socket.Release()
+	if err := s.socketLogin(socket); err != nil {
+		return err
+	}
+	return s.DB("admin").run(socket, cmd, result)
+}
+
+// ReplSetStatus holds the state of a replica set from the point of view
+// of the member reporting it, as returned by Session.ReplSetGetStatus.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/replSetGetStatus/
+//
+type ReplSetStatus struct {
+	Name    string    `bson:"set"`
+	Date    time.Time `bson:"date"`
+	MyState int       `bson:"myState"`
+	Term    int64     `bson:"term"` // On MongoDB 3.2+ with protocol version 1
+	Members []ReplSetMemberStatus
+}
+
+// ReplSetMemberStatus holds the state of an individual replica set member.
+type ReplSetMemberStatus struct {
+	Id       int `bson:"_id"`
+	Name     string
+	Healthy  bool `bson:"health"`
+	State    int
+	StateStr string `bson:"stateStr"`
+	Uptime   int64
+	Self     bool
+
+	OptimeDate    time.Time `bson:"optimeDate"`
+	LastHeartbeat time.Time `bson:"lastHeartbeat"`
+	PingMs        int64     `bson:"pingMs"`
+	ConfigVersion int       `bson:"configVersion"`
+
+	// SyncSource holds the address of the member this member
+	// replicates from, if any.
+	SyncSource string `bson:"-"`
+}
+
+// SetBSON implements bson.Setter.
+func (m *ReplSetMemberStatus) SetBSON(raw bson.Raw) error {
+	type memberStatus ReplSetMemberStatus
+	var doc struct {
+		memberStatus   `bson:",inline"`
+		SyncingTo      string `bson:"syncingTo"`
+		SyncSourceHost string `bson:"syncSourceHost"` // Renamed in MongoDB 4.4
+	}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+	*m = ReplSetMemberStatus(doc.memberStatus)
+	m.SyncSource = doc.SyncSourceHost
+	if m.SyncSource == "" {
+		m.SyncSource = doc.SyncingTo
+	}
+	return nil
+}
+
+// ReplSetGetStatus returns the state of the replica set as seen by
+// the member the session is talking to.
+func (s *Session) ReplSetGetStatus() (status *ReplSetStatus, err error) {
+	status = &ReplSetStatus{}
+	if err = s.Run(bson.D{{"replSetGetStatus", 1}}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ReplSetConfig holds the configuration of a replica set. Fields not
+// explicitly supported are preserved in Extra, so a configuration
+// obtained from ReplSetGetConfig may be changed and provided back to
+// ReplSetReconfig without losing settings.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/replica-configuration/
+//
+type ReplSetConfig struct {
+	Name            string   `bson:"_id"`
+	Version         int      `bson:"version"`
+	ProtocolVersion int      `bson:"protocolVersion,omitempty"`
+	Members         []Member `bson:"members"`
+	Settings        bson.M   `bson:"settings,omitempty"`
+	Extra           bson.M   `bson:",inline"`
+}
+
+// Member holds the configuration of an individual replica set member.
+// The pointer fields are left to the server default when nil.
+type Member struct {
+	Id           int               `bson:"_id"`
+	Host         string            `bson:"host"`
+	ArbiterOnly  bool              `bson:"arbiterOnly,omitempty"`
+	BuildIndexes *bool             `bson:"buildIndexes,omitempty"`
+	Hidden       bool              `bson:"hidden,omitempty"`
+	Priority     *float64          `bson:"priority,omitempty"`
+	Votes        *int              `bson:"votes,omitempty"`
+	Tags         map[string]string `bson:"tags,omitempty"`
+	Extra        bson.M            `bson:",inline"`
+}
+
+// ReplSetGetConfig returns the current configuration of the replica set
+// the session is talking to.
+func (s *Session) ReplSetGetConfig() (config *ReplSetConfig, err error) {
+	var result struct {
+		Config *ReplSetConfig
+	}
+	err = s.Run(bson.D{{"replSetGetConfig", 1}}, &result)
+	if isNoCmd(err) {
+		// Servers prior to 3.0 only hold it in the local database.
+		result.Config = &ReplSetConfig{}
+		err = s.DB("local").C("system.replset").Find(nil).One(result.Config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.Config, nil
+}
+
+// ReplSetReconfig replaces the configuration of the replica set with
+// config, whose Version must be greater than the one currently in use.
+// The command is sent to the primary, unless force is true, in which case
+// it's sent to the member the session is talking to. Forcing is meant for
+// recovering a replica set that lost the majority of its members.
+//
+// For example:
+//
+//     config, err := session.ReplSetGetConfig()
+//     ...
+//     config.Version++
+//     config.Members = append(config.Members, mgo.Member{Id: 3, Host: "host3:27017"})
+//     err = session.ReplSetReconfig(config, false)
+//
+func (s *Session) ReplSetReconfig(config *ReplSetConfig, force bool) error {
+	cmd := bson.D{{"replSetReconfig", config}, {"force", force}}
+	if force {
+		return s.Run(cmd, nil)
+	}
+	session := s.Clone()
+	defer session.Close()
+	session.SetMode(Strong, false)
+	return session.Run(cmd, nil)
+}
+
+// ReplSetStepDown asks the primary to step down, so that an eligible
+// secondary is elected, and to not seek reelection for secs seconds.
+// Servers prior to 4.2 close all connections when stepping down, so
+// connection errors that follow are not reported.
+func (s *Session) ReplSetStepDown(secs int) error {
+	// Use a new socket, as the one reserved by s may be closed.
+	session := s.Copy()
+	defer session.Close()
+	session.SetMode(Strong, false)
+	err := session.Run(bson.D{{"replSetStepDown", secs}}, nil)
+	if isConnError(err) {
+		return nil
+	}
+	return err
+}
+
+// isConnError returns whether err reports the connection to the
+// server being broken or closed, rather than an error from the server.
+func isConnError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == errSocketClosed {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// ReplSetFreeze prevents the member with the provided address, as listed
+// in the replica set configuration, from seeking election as primary for
+// secs seconds. A secs value of zero unfreezes the member. If addr is empty
+// the command is sent to the member the session is talking to.
+func (s *Session) ReplSetFreeze(addr string, secs int) error {
+	return s.runOnMember(addr, bson.D{{"replSetFreeze", secs}}, nil)
+}
+
+// ReplSetInitiate initiates a new replica set with config. The session
+// must be talking directly to one of the members in config, which is
+// usually achieved by dialing it with the Direct option.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/replSetInitiate/
+//
+func (s *Session) ReplSetInitiate(config *ReplSetConfig) error {
+	return s.Run(bson.D{{"replSetInitiate", config}}, nil)
+}
+
+// runOnMongos runs cmd against the admin database of a mongos router,
+// or fails with ErrNoMongos if the session is not talking to one.
+func (s *Session) runOnMongos(cmd, result interface{}) error {
+	session := s.Clone()
+	defer session.Close()
+	session.SetMode(Strong, false)
+
+	socket, err := session.acquireSocket(false)
+	if err != nil {
+		return err
+	}
+	defer socket.Release()
+	if !session.cluster().HasMongos() {
+		return ErrNoMongos
+	}
+	return session.DB("admin").run(socket, cmd, result)
+}
+
+// Shard holds the details of a shard in a sharded cluster, as
+// reported by Session.ListShards.
+type Shard struct {
+	Name     string `bson:"_id"`
+	Host     string
+	Draining bool
+	Tags     []string
+	State    int
+}
+
+// ListShards returns the shards in the cluster the session is talking
+// to via a mongos router. ErrNoMongos is returned if the session is
+// not talking to a mongos.
+func (s *Session) ListShards() ([]Shard, error) {
+	var result struct{ Shards []Shard }
+	if err := s.runOnMongos(bson.D{{"listShards", 1}}, &result); err != nil {
+		return nil, err
+	}
+	return result.Shards, nil
+}
+
+// AddShard adds the server or replica set at addr to the sharded cluster
+// as a new shard, and returns the name of the shard. Replica sets are
+// provided as "setname/host1,host2". If name is empty the server picks a
+// name for the shard.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/addShard/
+//
+func (s *Session) AddShard(addr, name string) (shardName string, err error) {
+	cmd := bson.D{{"addShard", addr}}
+	if name != "" {
+		cmd = append(cmd, bson.DocElem{"name", name})
+	}
+	var result struct {
+		ShardAdded string `bson:"shardAdded"`
+	}
+	if err = s.runOnMongos(cmd, &result); err != nil {
+		return "", err
+	}
+	return result.ShardAdded, nil
+}
+
+// RemoveShardStatus holds the progress of removing a shard, as
+// reported by Session.RemoveShard.
+type RemoveShardStatus struct {
+	// State is "started", "ongoing" or "completed".
+	State string
+	Msg   string
+
+	// Remaining holds what is yet to be moved off the shard
+	// while it's being drained.
+	Remaining struct {
+		Chunks      int64
+		DBs         int64 `bson:"dbs"`
+		JumboChunks int64 `bson:"jumboChunks"`
+	}
+
+	// DBsToMove holds the databases with the shard as their primary
+	// shard, which must be moved elsewhere via movePrimary before the
+	// removal completes.
+	DBsToMove []string `bson:"dbsToMove"`
+}
+
+// RemoveShard starts draining the shard with the provided name, or
+// reports the progress of a previously started removal. The shard is
+// only removed once RemoveShard reports the "completed" state, so it
+// must be called repeatedly until then.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/removeShard/
+//
+func (s *Session) RemoveShard(name string) (status *RemoveShardStatus, err error) {
+	status = &RemoveShardStatus{}
+	if err = s.runOnMongos(bson.D{{"removeShard", name}}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// EnableSharding allows the collections in the database with the
+// provided name to be sharded.
+func (s *Session) EnableSharding(dbname string) error {
+	return s.runOnMongos(bson.D{{"enableSharding", dbname}}, nil)
+}
+
+// ShardCollection shards the collection with the provided full name,
+// such as "mydb.mycoll", by key. The database must have sharding
+// enabled. See EnsureIndex for details on the accepted key variants.
+// If unique is true the shard key index enforces uniqueness. For hashed
+// keys, presplit optionally defines the number of chunks initially
+// created for an empty collection.
+//
+// For example:
+//
+//     err := session.ShardCollection("mydb.mycoll", []string{"$hashed:userid"}, false, 64)
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/shardCollection/
+//
+func (s *Session) ShardCollection(ns string, key []string, unique bool, presplit int) error {
+	keyInfo, err := parseIndexKey(key)
+	if err != nil {
+		return err
+	}
+	cmd := bson.D{{"shardCollection", ns}, {"key", keyInfo.key}}
+	if unique {
+		cmd = append(cmd, bson.DocElem{"unique", true})
+	}
+	if presplit > 0 {
+		cmd = append(cmd, bson.DocElem{"numInitialChunks", presplit})
+	}
+	return s.runOnMongos(cmd, nil)
+}
+
+// SplitChunk splits the chunk of the collection with the provided full
+// name that holds the middle shard key value, using that value as the
+// new boundary.
+//
+// For example:
+//
+//     err := session.SplitChunk("mydb.mycoll", bson.M{"userid": 1000})
+//
+func (s *Session) SplitChunk(ns string, middle interface{}) error {
+	return s.runOnMongos(bson.D{{"split", ns}, {"middle", middle}}, nil)
+}
+
+// MoveChunk moves the chunk of the collection with the provided full
+// name that holds the find shard key value onto the shard named toShard.
+func (s *Session) MoveChunk(ns string, find interface{}, toShard string) error {
+	return s.runOnMongos(bson.D{{"moveChunk", ns}, {"find", find}, {"to", toShard}}, nil)
+}
+
+// BalancerStatus holds the state of the balancer, as reported by
+// Session.BalancerStatus.
+type BalancerStatus struct {
+	// Mode is "full" when the balancer is enabled and "off" otherwise.
+	Mode string
+
+	// InBalancerRound reports whether the balancer is currently
+	// moving chunks. Only reported by MongoDB 3.4+.
+	InBalancerRound bool `bson:"inBalancerRound"`
+
+	NumBalancerRounds int64 `bson:"numBalancerRounds"`
+}
+
+// BalancerStart enables the balancer, which moves chunks across
+// shards to keep them evenly distributed.
+func (s *Session) BalancerStart() error {
+	return s.setBalancer("balancerStart", false)
+}
+
+// BalancerStop disables the balancer, waiting for an ongoing
+// balancing round to finish.
+func (s *Session) BalancerStop() error {
+	return s.setBalancer("balancerStop", true)
+}
+
+func (s *Session) setBalancer(cmdName string, stopped bool) error {
+	err := s.runOnMongos(bson.D{{cmdName, 1}}, nil)
+	if !isNoCmd(err) {
+		return err
+	}
+	// Servers prior to 3.4 hold the balancer state in the config database.
+	session := s.Clone()
+	defer session.Close()
+	session.SetMode(Strong, false)
+	settings := session.DB("config").C("settings")
+	_, err = settings.Upsert(bson.D{{"_id", "balancer"}}, bson.D{{"$set", bson.D{{"stopped", stopped}}}})
+	return err
+}
+
+// BalancerStatus returns the state of the balancer.
+func (s *Session) BalancerStatus() (status *BalancerStatus, err error) {
+	status = &BalancerStatus{}
+	err = s.runOnMongos(bson.D{{"balancerStatus", 1}}, status)
+	if !isNoCmd(err) {
+		if err != nil {
+			return nil, err
+		}
+		return status, nil
+	}
+	// Servers prior to 3.4 hold the balancer state in the config database.
+	session := s.Clone()
+	defer session.Close()
+	session.SetMode(Strong, false)
+	var settings struct{ Stopped bool }
+	err = session.DB("config").C("settings").FindId("balancer").One(&settings)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	status.Mode = "full"
+	if settings.Stopped {
+		status.Mode = "off"
+	}
+	return status, nil
+}
+
+// ProfilingStatus holds the profiler settings of a database, as
+// reported by Database.ProfilingStatus.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/tutorial/manage-the-database-profiler/
+//
+type ProfilingStatus struct {
+	// Level is 0 when the profiler is off, 1 when it records
+	// operations slower than Slow, and 2 when it records all
+	// operations.
+	Level int
+
+	// Slow is the threshold for an operation to be considered slow.
+	Slow time.Duration
+
+	// SampleRate is the fraction of slow operations that get
+	// recorded. Only reported by MongoDB 3.6+.
+	SampleRate float64
+}
+
+// ProfilingStatus returns the profiler settings of the db database.
+func (db *Database) ProfilingStatus() (status *ProfilingStatus, err error) {
+	var result struct {
+		Was        int
+		SlowMs     int     `bson:"slowms"`
+		SampleRate float64 `bson:"sampleRate"`
+	}
+	if err = db.Run(bson.D{{"profile", -1}}, &result); err != nil {
+		return nil, err
+	}
+	status = &ProfilingStatus{
+		Level:      result.Was,
+		Slow:       time.Duration(result.SlowMs) * time.Millisecond,
+		SampleRate: result.SampleRate,
+	}
+	return status, nil
+}
+
+// SetProfilingLevel changes the profiler settings of the db database.
+// See ProfilingStatus for the meaning of the parameters. A zero slow
+// or sampleRate leaves the respective setting unchanged.
+//
+// For example:
+//
+//     err := db.SetProfilingLevel(1, 50*time.Millisecond, 0)
+//
+func (db *Database) SetProfilingLevel(level int, slow time.Duration, sampleRate float64) error {
+	cmd := bson.D{{"profile", level}}
+	if slow > 0 {
+		cmd = append(cmd, bson.DocElem{"slowms", int(slow / time.Millisecond)})
+	}
+	if sampleRate > 0 {
+		cmd = append(cmd, bson.DocElem{"sampleRate", sampleRate})
+	}
+	return db.Run(cmd, nil)
+}
+
+// ProfileEntry holds an operation recorded by the database profiler.
+// The full document recorded by the server is available in Raw.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/database-profiler/
+//
+type ProfileEntry struct {
+	Op        string
+	Namespace string    `bson:"ns"`
+	Timestamp time.Time `bson:"ts"`
+	Client    string
+	User      string
+
+	// Command holds the command or query run. Servers prior
+	// to 3.2 report queries only.
+	Command bson.M
+
+	DocsExamined   int64  `bson:"-"`
+	KeysExamined   int64  `bson:"-"`
+	NReturned      int64  `bson:"nreturned"`
+	ResponseLength int64  `bson:"responseLength"`
+	PlanSummary    string `bson:"planSummary"`
+	Millis         int64
+
+	Raw bson.M `bson:"-"`
+}
+
+// SetBSON implements bson.Setter.
+func (entry *ProfileEntry) SetBSON(raw bson.Raw) error {
+	type profileEntry ProfileEntry
+	var doc struct {
+		profileEntry `bson:",inline"`
+		Query        bson.M
+		DocsExamined int64 `bson:"docsExamined"`
+		KeysExamined int64 `bson:"keysExamined"`
+
+		// Names used by servers prior to 3.2.
+		NScannedObjects int64 `bson:"nscannedObjects"`
+		NScanned        int64 `bson:"nscanned"`
+	}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+	*entry = ProfileEntry(doc.profileEntry)
+	if entry.Command == nil {
+		entry.Command = doc.Query
+	}
+	entry.DocsExamined = doc.DocsExamined + doc.NScannedObjects
+	entry.KeysExamined = doc.KeysExamined + doc.NScanned
+	return raw.Unmarshal(&entry.Raw)
+}
+
+// ProfileIter iterates over the entries recorded by the database profiler.
+// See Iter for details on the behavior of its methods.
+type ProfileIter struct {
+	iter *Iter
+
+	// skip entries recorded at skipTs were already
+	// recorded when tailing started.
+	skip   int
+	skipTs time.Time
+}
+
+// Next retrieves the next profiler entry into entry, returning false
+// when the iteration is over, times out, or fails.
+func (p *ProfileIter) Next(entry *ProfileEntry) bool {
+	for {
+		*entry = ProfileEntry{}
+		if !p.iter.Next(entry) {
+			return false
+		}
+		if p.skip > 0 && entry.Timestamp.Equal(p.skipTs) {
+			p.skip--
+			continue
+		}
+		return true
+	}
+}
+
+// Err returns nil if no errors happened during iteration, or
+// the actual error otherwise.
+func (p *ProfileIter) Err() error {
+	return p.iter.Err()
+}
+
+// Timeout returns true if Next returned false due to a timeout of
+// an iterator created with Database.TailProfile.
+func (p *ProfileIter) Timeout() bool {
+	return p.iter.Timeout()
+}
+
+// Close kills the server cursor used by the iterator, if any, and
+// returns nil if no errors happened during iteration, or the actual
+// error otherwise.
+func (p *ProfileIter) Close() error {
+	return p.iter.Close()
+}
+
+// ProfileEntries returns an iterator over the entries recorded by the
+// profiler of the db database that match query, in the order they
+// were recorded.
+//
+// For example:
+//
+//     iter := db.ProfileEntries(bson.M{"millis": bson.M{"$gt": 100}})
+//     var entry mgo.ProfileEntry
+//     for iter.Next(&entry) {
+//         fmt.Println(entry.Namespace, entry.PlanSummary, entry.Millis)
+//     }
+//     err := iter.Close()
+//
+func (db *Database) ProfileEntries(query interface{}) *ProfileIter {
+	return &ProfileIter{iter: db.C("system.profile").Find(query).Sort("$natural").Iter()}
+}
+
+// TailProfile returns an iterator over the entries matching query that
+// the profiler of the db database records from now on. The iterator
+// blocks waiting for new entries as done by Query.Tail with timeout.
+//
+// Profiler timestamps have millisecond resolution, so "now" is the
+// timestamp of the last entry recorded, and the entries that already
+// carry it are counted and skipped. Entries recorded in that same
+// millisecond while TailProfile runs, between the count and the start
+// of the tailing, are skipped as well. Entries recorded in any later
+// millisecond are always delivered, and never twice.
+func (db *Database) TailProfile(query interface{}, timeout time.Duration) *ProfileIter {
+	profile := db.C("system.profile")
+	var last struct {
+		Ts time.Time
+	}
+	err := profile.Find(nil).Sort("-$natural").One(&last)
+	if err != nil && err != ErrNotFound {
+		return &ProfileIter{iter: errIter(db.Session, err)}
+	}
+	p := &ProfileIter{}
+	if !last.Ts.IsZero() {
+		// Timestamps have millisecond resolution, so entries recorded
+		// along with the last one are included, and those that existed
+		// already are skipped by Next.
+		and := func(cond bson.M) interface{} {
+			if query == nil {
+				return cond
+			}
+			return bson.M{"$and": []interface{}{query, cond}}
+		}
+		p.skipTs = last.Ts
+		p.skip, err = profile.Find(and(bson.M{"ts": last.Ts})).Count()
+		if err != nil {
+			return &ProfileIter{iter: errIter(db.Session, err)}
+		}
+		query = and(bson.M{"ts": bson.M{"$gte": last.Ts}})
+	}
+	p.iter = profile.Find(query).Sort("$natural").Tail(timeout)
+	return p
+}
+
+// errIter returns an iterator on session that fails with err.
+func errIter(session *Session, err error) *Iter {
+	iter := &Iter{session: session, timeout: -1}
+	iter.gotReply.L = &iter.m
+	iter.err = err
+	return iter
+}
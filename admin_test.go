@@ -0,0 +1,462 @@
+package mgo_test
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+func (s *S) TestServerStatus(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	status, err := session.ServerStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.Process, Equals, "mongod")
+	c.Assert(status.Version, Not(Equals), "")
+	c.Assert(status.Pid > 0, Equals, true)
+	c.Assert(status.Uptime > 0, Equals, true)
+	c.Assert(status.Connections.Current > 0, Equals, true)
+	c.Assert(status.StorageEngine, Not(Equals), "")
+	c.Assert(status.Repl, IsNil)
+	c.Assert(status.Raw["host"], Equals, status.Host)
+
+	session, err = mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	status, err = session.ServerStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.Repl, NotNil)
+	c.Assert(status.Repl.SetName, Equals, "rs1")
+}
+
+func (s *S) TestCurrentOpAndKillOp(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	done := make(chan error)
+	go func() {
+		// Runs for about 30 seconds unless killed.
+		query := coll.Find(M{"$where": "function() { sleep(30000); return true; }"})
+		done <- query.One(nil)
+	}()
+
+	var op *mgo.Operation
+	for i := 0; i < 100 && op == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+		ops, err := session.CurrentOp(bson.M{"ns": "mydb.mycoll"})
+		c.Assert(err, IsNil)
+		for i := range ops {
+			if ops[i].Op == "query" {
+				op = &ops[i]
+			}
+		}
+	}
+	c.Assert(op, NotNil)
+	c.Assert(op.Namespace, Equals, "mydb.mycoll")
+	c.Assert(op.Active, Equals, true)
+	c.Assert(op.Command, NotNil)
+
+	err = session.KillOp(op.OpId)
+	c.Assert(err, IsNil)
+
+	select {
+	case err := <-done:
+		c.Assert(err, ErrorMatches, ".*(interrupted|killed).*")
+	case <-time.After(20 * time.Second):
+		c.Fatalf("operation was not killed")
+	}
+}
+
+func (s *S) TestDatabaseStats(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	for i := 0; i < 10; i++ {
+		err := db.C("mycoll").Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	stats, err := db.Stats(0)
+	c.Assert(err, IsNil)
+	c.Assert(stats.DB, Equals, "mydb")
+	c.Assert(stats.Objects >= 10, Equals, true)
+	c.Assert(stats.DataSize > 0, Equals, true)
+	c.Assert(stats.Scale, Equals, 1)
+
+	kstats, err := db.Stats(1024)
+	c.Assert(err, IsNil)
+	c.Assert(kstats.Scale, Equals, 1024)
+	c.Assert(kstats.DataSize <= stats.DataSize/1024+1, Equals, true)
+}
+
+func (s *S) TestCollectionStats(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.EnsureIndexKey("n")
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		err := coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	stats, err := coll.Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Namespace, Equals, "mydb.mycoll")
+	c.Assert(stats.Count, Equals, int64(10))
+	c.Assert(stats.NIndexes, Equals, 2)
+	c.Assert(stats.IndexSizes["_id_"] > 0, Equals, true)
+	c.Assert(stats.IndexSizes["n_1"] > 0, Equals, true)
+	if s.versionAtLeast(3, 2) {
+		c.Assert(stats.IndexStats, HasLen, 2)
+		names := map[string]bool{}
+		for _, index := range stats.IndexStats {
+			names[index.Name] = true
+		}
+		c.Assert(names, DeepEquals, map[string]bool{"_id_": true, "n_1": true})
+	} else {
+		c.Assert(stats.IndexStats, HasLen, 0)
+	}
+
+	indexStats, err := coll.IndexStats()
+	c.Assert(err, IsNil)
+	c.Assert(indexStats, HasLen, len(stats.IndexStats))
+}
+
+func (s *S) TestReplSetGetStatus(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	status, err := session.ReplSetGetStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.Name, Equals, "rs1")
+	c.Assert(status.MyState, Equals, 1)
+	c.Assert(status.Members, HasLen, 3)
+
+	var self, secondaries int
+	for _, member := range status.Members {
+		c.Assert(member.Healthy, Equals, true)
+		if member.Self {
+			self++
+			c.Assert(hostPort(member.Name), Equals, "40011")
+			c.Assert(member.StateStr, Equals, "PRIMARY")
+		} else if member.State == 2 {
+			secondaries++
+			c.Assert(member.StateStr, Equals, "SECONDARY")
+		}
+	}
+	c.Assert(self, Equals, 1)
+	c.Assert(secondaries, Equals, 2)
+}
+
+func (s *S) TestReplSetGetConfig(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	config, err := session.ReplSetGetConfig()
+	c.Assert(err, IsNil)
+	c.Assert(config.Name, Equals, "rs1")
+	c.Assert(config.Version > 0, Equals, true)
+	c.Assert(config.Members, HasLen, 3)
+
+	member := config.Members[1]
+	c.Assert(member.Id, Equals, 2)
+	c.Assert(member.Host, Equals, "127.0.0.1:40012")
+	c.Assert(member.Priority, NotNil)
+	c.Assert(*member.Priority, Equals, 0.0)
+	c.Assert(member.Tags, DeepEquals, map[string]string{"rs1": "b"})
+}
+
+func (s *S) TestReplSetReconfig(c *C) {
+	session, err := mgo.Dial("localhost:40031")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	config, err := session.ReplSetGetConfig()
+	c.Assert(err, IsNil)
+	version := config.Version
+
+	config.Version++
+	config.Members[2].Tags["extra"] = "yes"
+	err = session.ReplSetReconfig(config, false)
+	c.Assert(err, IsNil)
+
+	config, err = session.ReplSetGetConfig()
+	c.Assert(err, IsNil)
+	c.Assert(config.Version, Equals, version+1)
+	c.Assert(config.Members[2].Tags, DeepEquals, map[string]string{"rs3": "c", "extra": "yes"})
+
+	// The version must increase on every change.
+	delete(config.Members[2].Tags, "extra")
+	err = session.ReplSetReconfig(config, false)
+	c.Assert(err, NotNil)
+
+	config.Version++
+	err = session.ReplSetReconfig(config, false)
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestReplSetFreezeAndStepDown(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	// Leave rs2 with a primary for the tests that follow.
+	defer func() {
+		for i := 0; ; i++ {
+			session.Refresh()
+			session.SetSyncTimeout(5 * time.Second)
+			if session.Ping() == nil {
+				break
+			}
+			if i == 12 {
+				c.Fatal("rs2 has no primary after the step down")
+			}
+		}
+	}()
+
+	result := &struct{ Host string }{}
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+	master := hostPort(result.Host)
+
+	// Freeze one of the secondaries so the other one is elected.
+	var frozen string
+	for _, port := range []string{"40021", "40022", "40023"} {
+		if port != master {
+			frozen = port
+			break
+		}
+	}
+	err = session.ReplSetFreeze("127.0.0.1:"+frozen, 60)
+	c.Assert(err, IsNil)
+	defer session.ReplSetFreeze("127.0.0.1:"+frozen, 0)
+
+	// Must exceed the 10 seconds the primary waits for secondaries to catch up.
+	err = session.ReplSetStepDown(15)
+	c.Assert(err, IsNil)
+
+	session.Refresh()
+	session.SetSyncTimeout(time.Minute)
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+	c.Assert(hostPort(result.Host), Not(Equals), master)
+	c.Assert(hostPort(result.Host), Not(Equals), frozen)
+}
+
+func (s *S) TestShardingRequiresMongos(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	_, err = session.ListShards()
+	c.Assert(err, Equals, mgo.ErrNoMongos)
+	err = session.EnableSharding("mydb")
+	c.Assert(err, Equals, mgo.ErrNoMongos)
+}
+
+func (s *S) TestListShards(c *C) {
+	session, err := mgo.Dial("localhost:40201")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	shards, err := session.ListShards()
+	c.Assert(err, IsNil)
+	c.Assert(shards, HasLen, 2)
+
+	var hosts []string
+	for _, shard := range shards {
+		c.Assert(shard.Name, Not(Equals), "")
+		c.Assert(shard.Draining, Equals, false)
+		hosts = append(hosts, shard.Host)
+	}
+	sort.Strings(hosts)
+	c.Assert(hosts[0], Equals, "127.0.0.1:40001")
+	c.Assert(strings.HasPrefix(hosts[1], "rs1/"), Equals, true)
+
+	status, err := session.RemoveShard("unknown")
+	c.Assert(err, NotNil)
+	c.Assert(status, IsNil)
+}
+
+func (s *S) TestShardCollection(c *C) {
+	session, err := mgo.Dial("localhost:40201")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.EnableSharding("mydb")
+	c.Assert(err, IsNil)
+	err = session.ShardCollection("mydb.mycoll", []string{"n"}, false, 0)
+	c.Assert(err, IsNil)
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 10; i++ {
+		err := coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+	err = session.SplitChunk("mydb.mycoll", M{"n": 5})
+	c.Assert(err, IsNil)
+
+	// Move the upper chunk away from the primary shard of the database.
+	var dbinfo struct{ Primary string }
+	err = session.DB("config").C("databases").FindId("mydb").One(&dbinfo)
+	c.Assert(err, IsNil)
+	shards, err := session.ListShards()
+	c.Assert(err, IsNil)
+	var target mgo.Shard
+	for _, shard := range shards {
+		if shard.Name != dbinfo.Primary {
+			target = shard
+		}
+	}
+	err = session.MoveChunk("mydb.mycoll", M{"n": 7}, target.Name)
+	c.Assert(err, IsNil)
+
+	addr := target.Host
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr = addr[i+1:]
+	}
+	direct, err := mgo.Dial(addr)
+	c.Assert(err, IsNil)
+	defer direct.Close()
+	n, err := direct.DB("mydb").C("mycoll").Find(M{"n": M{"$gte": 5}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 5)
+
+	n, err = coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+}
+
+func (s *S) TestBalancer(c *C) {
+	session, err := mgo.Dial("localhost:40201")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.BalancerStop()
+	c.Assert(err, IsNil)
+	defer session.BalancerStart()
+
+	status, err := session.BalancerStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.Mode, Equals, "off")
+
+	err = session.BalancerStart()
+	c.Assert(err, IsNil)
+
+	status, err = session.BalancerStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.Mode, Equals, "full")
+}
+
+func (s *S) TestProfilingLevel(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	err = db.SetProfilingLevel(1, 42*time.Millisecond, 0)
+	c.Assert(err, IsNil)
+	defer db.SetProfilingLevel(0, 100*time.Millisecond, 0)
+
+	status, err := db.ProfilingStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.Level, Equals, 1)
+	c.Assert(status.Slow, Equals, 42*time.Millisecond)
+
+	if s.versionAtLeast(3, 6) {
+		err = db.SetProfilingLevel(1, 0, 0.5)
+		c.Assert(err, IsNil)
+		status, err = db.ProfilingStatus()
+		c.Assert(err, IsNil)
+		c.Assert(status.SampleRate, Equals, 0.5)
+		c.Assert(status.Slow, Equals, 42*time.Millisecond)
+	}
+}
+
+func (s *S) TestProfileEntries(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	coll := db.C("mycoll")
+	for i := 0; i < 10; i++ {
+		err := coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	err = db.SetProfilingLevel(2, 0, 0)
+	c.Assert(err, IsNil)
+	defer db.SetProfilingLevel(0, 0, 0)
+
+	err = coll.Find(M{"n": M{"$gte": 7}}).All(nil)
+	c.Assert(err, IsNil)
+
+	iter := db.ProfileEntries(M{"ns": "mydb.mycoll", "op": "query"})
+	var entry mgo.ProfileEntry
+	c.Assert(iter.Next(&entry), Equals, true)
+	c.Assert(iter.Close(), IsNil)
+
+	c.Assert(entry.Namespace, Equals, "mydb.mycoll")
+	c.Assert(entry.Command, NotNil)
+	c.Assert(entry.DocsExamined, Equals, int64(10))
+	c.Assert(entry.NReturned, Equals, int64(3))
+	c.Assert(entry.Timestamp.IsZero(), Equals, false)
+	if s.versionAtLeast(3, 0) {
+		c.Assert(entry.PlanSummary, Equals, "COLLSCAN")
+	}
+}
+
+func (s *S) TestTailProfile(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	coll := db.C("mycoll")
+
+	err = db.SetProfilingLevel(2, 0, 0)
+	c.Assert(err, IsNil)
+	defer db.SetProfilingLevel(0, 0, 0)
+
+	// Make sure there's something recorded before tailing.
+	err = coll.Insert(M{"n": 0})
+	c.Assert(err, IsNil)
+
+	iter := db.TailProfile(M{"ns": "mydb.mycoll", "op": "insert"}, 2*time.Second)
+	defer iter.Close()
+
+	var entry mgo.ProfileEntry
+	c.Assert(iter.Next(&entry), Equals, false)
+	c.Assert(iter.Timeout(), Equals, true)
+
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	c.Assert(iter.Next(&entry), Equals, true)
+	c.Assert(entry.Op, Equals, "insert")
+	c.Assert(entry.Namespace, Equals, "mydb.mycoll")
+	c.Assert(iter.Err(), IsNil)
+}
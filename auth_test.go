@@ -35,6 +35,7 @@ import (
 	"net/url"
 	"os"
 	"runtime"
+	"sort"
 	"sync"
 	"time"
 
@@ -340,6 +341,127 @@ func (s *S) TestAuthUpsertUserUpdates(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *S) TestAuthRoles(c *C) {
+	if !s.versionAtLeast(2, 6) {
+		c.Skip("role management only works on 2.6+")
+	}
+	session, err := mgo.Dial("localhost:40002")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	admindb := session.DB("admin")
+	err = admindb.Login("root", "rapadura")
+	c.Assert(err, IsNil)
+
+	mydb := session.DB("mydb")
+	err = mydb.CreateRole(&mgo.RoleInfo{
+		Name: "myrole",
+		Privileges: []mgo.Privilege{{
+			Resource: mgo.Resource{DB: "mydb", Collection: "mycoll"},
+			Actions:  []string{"find"},
+		}},
+	})
+	c.Assert(err, IsNil)
+
+	err = mydb.UpsertUser(&mgo.User{Username: "myruser", Password: "mypass", Roles: []mgo.Role{}})
+	c.Assert(err, IsNil)
+	err = mydb.GrantRolesToUser("myruser", "myrole")
+	c.Assert(err, IsNil)
+
+	usession, err := mgo.Dial("myruser:mypass@localhost:40002/mydb")
+	c.Assert(err, IsNil)
+	defer usession.Close()
+
+	// Can read mycoll, but not insert into it.
+	ucoll := usession.DB("mydb").C("mycoll")
+	err = ucoll.Find(nil).One(nil)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	err = ucoll.Insert(M{"n": 1})
+	c.Assert(err, ErrorMatches, "unauthorized|not authorized .*")
+
+	err = mydb.GrantPrivilegesToRole("myrole", mgo.Privilege{
+		Resource: mgo.Resource{DB: "mydb", Collection: "mycoll"},
+		Actions:  []string{"insert"},
+	})
+	c.Assert(err, IsNil)
+	err = ucoll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	roles, err := mydb.RolesInfo("myrole")
+	c.Assert(err, IsNil)
+	c.Assert(roles, HasLen, 1)
+	c.Assert(roles[0].Name, Equals, "myrole")
+	c.Assert(roles[0].IsBuiltin, Equals, false)
+	c.Assert(roles[0].Privileges, HasLen, 1)
+	c.Assert(roles[0].Privileges[0].Resource, Equals, mgo.Resource{DB: "mydb", Collection: "mycoll"})
+	c.Assert(roles[0].Privileges[0].Actions, HasLen, 2)
+
+	err = mydb.UpdateRole(&mgo.RoleInfo{Name: "myrole", Privileges: []mgo.Privilege{}, Roles: []mgo.Role{mgo.RoleRead}})
+	c.Assert(err, IsNil)
+	roles, err = mydb.RolesInfo("myrole")
+	c.Assert(err, IsNil)
+	c.Assert(roles[0].Privileges, HasLen, 0)
+	c.Assert(roles[0].Roles, DeepEquals, []mgo.Role{mgo.RoleRead})
+
+	err = mydb.RevokeRolesFromUser("myruser", "myrole")
+	c.Assert(err, IsNil)
+	err = ucoll.Find(nil).One(nil)
+	c.Assert(err, ErrorMatches, "unauthorized|not authorized .*")
+
+	err = mydb.DropRole("myrole")
+	c.Assert(err, IsNil)
+	err = mydb.DropRole("myrole")
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	err = mydb.UpdateRole(&mgo.RoleInfo{Name: "myrole", Roles: []mgo.Role{}})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestAuthUsersInfo(c *C) {
+	// Servers prior to 2.6 are queried via system.users.
+	session, err := mgo.Dial("localhost:40002")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	admindb := session.DB("admin")
+	err = admindb.Login("root", "rapadura")
+	c.Assert(err, IsNil)
+
+	user := &mgo.User{
+		Username:     "myruser",
+		Password:     "mypass",
+		Roles:        []mgo.Role{mgo.RoleRead},
+		OtherDBRoles: map[string][]mgo.Role{"mydb": {mgo.RoleReadWrite}},
+	}
+	if s.versionAtLeast(3, 6) {
+		user.AuthenticationRestrictions = []mgo.AuthenticationRestriction{{ClientSource: []string{"127.0.0.1"}}}
+	}
+	if s.versionAtLeast(4, 0) {
+		user.Mechanisms = []string{"SCRAM-SHA-256"}
+	}
+	err = admindb.UpsertUser(user)
+	c.Assert(err, IsNil)
+
+	users, err := admindb.UsersInfo("myruser")
+	c.Assert(err, IsNil)
+	c.Assert(users, HasLen, 1)
+	c.Assert(users[0].Username, Equals, "myruser")
+	c.Assert(users[0].Password, Equals, "")
+	c.Assert(users[0].PasswordHash, Equals, "")
+	c.Assert(users[0].Roles, DeepEquals, []mgo.Role{mgo.RoleRead})
+	c.Assert(users[0].OtherDBRoles, DeepEquals, map[string][]mgo.Role{"mydb": {mgo.RoleReadWrite}})
+	c.Assert(users[0].AuthenticationRestrictions, DeepEquals, user.AuthenticationRestrictions)
+	c.Assert(users[0].Mechanisms, DeepEquals, user.Mechanisms)
+
+	users, err = admindb.UsersInfo()
+	c.Assert(err, IsNil)
+	var names []string
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	sort.Strings(names)
+	c.Assert(names, DeepEquals, []string{"myruser", "reader", "root"})
+}
+
 func (s *S) TestAuthAddUser(c *C) {
 	session, err := mgo.Dial("localhost:40002")
 	c.Assert(err, IsNil)
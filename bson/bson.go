@@ -494,6 +494,12 @@ func handleErr(err *error) {
 //                they were part of the outer struct. For maps, keys must
 //                not conflict with the bson keys of other struct fields.
 //
+//     encrypt=deterministic, encrypt=random, key=<name>
+//                Mark the field as encrypted with the given algorithm
+//                and the data key of the given name. The flags have no
+//                effect on marshalling itself, but are reported by
+//                EncryptedFields for drivers that encrypt such fields.
+//
 // Some examples:
 //
 //     type T struct {
@@ -620,6 +626,9 @@ type fieldInfo struct {
 	OmitEmpty bool
 	MinSize   bool
 	Inline    []int
+
+	Encrypt    string
+	EncryptKey string
 }
 
 var structMap = make(map[reflect.Type]*structInfo)
@@ -669,11 +678,21 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 					info.MinSize = true
 				case "inline":
 					inline = true
+				case "encrypt=deterministic", "encrypt=random":
+					info.Encrypt = flag[len("encrypt="):]
 				default:
+					if strings.HasPrefix(flag, "key=") && len(flag) > len("key=") {
+						info.EncryptKey = flag[len("key="):]
+						break
+					}
 					msg := fmt.Sprintf("Unsupported flag %q in tag %q of type %s", flag, tag, st)
 					panic(externalPanic(msg))
 				}
 			}
+			if info.Encrypt != "" && info.EncryptKey == "" || info.Encrypt == "" && info.EncryptKey != "" {
+				msg := fmt.Sprintf("Flags encrypt and key must be used together in tag %q of type %s", tag, st)
+				panic(externalPanic(msg))
+			}
 			tag = fields[0]
 		}
 
@@ -736,3 +755,60 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 	structMapMutex.Unlock()
 	return sinfo, nil
 }
+
+// EncryptedField describes a struct field marked as encrypted via
+// the encrypt and key tag flags. See Marshal for details.
+type EncryptedField struct {
+	// Path holds the dotted path of the field in the marshalled
+	// document, such as "address.street" for nested structs.
+	Path string
+
+	// Algorithm is either "deterministic" or "random".
+	Algorithm string
+
+	// KeyName is the name of the data key to encrypt the field with.
+	KeyName string
+}
+
+// EncryptedFields returns the fields of the struct type t that are marked
+// as encrypted, including those in inlined structs and in structs nested
+// as the value of other fields.
+func EncryptedFields(t reflect.Type) (fields []EncryptedField, err error) {
+	defer handleErr(&err)
+	return encryptedFields(t, "", make(map[reflect.Type]bool))
+}
+
+func encryptedFields(t reflect.Type, prefix string, seen map[reflect.Type]bool) ([]EncryptedField, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == typeTime || t == typeRaw || seen[t] {
+		return nil, nil
+	}
+	sinfo, err := getStructInfo(t)
+	if err != nil {
+		return nil, err
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	var fields []EncryptedField
+	for _, info := range sinfo.FieldsList {
+		if info.Encrypt != "" {
+			fields = append(fields, EncryptedField{prefix + info.Key, info.Encrypt, info.EncryptKey})
+			continue
+		}
+		var ft reflect.Type
+		if info.Inline == nil {
+			ft = t.Field(info.Num).Type
+		} else {
+			ft = t.FieldByIndex(info.Inline).Type
+		}
+		nested, err := encryptedFields(ft, prefix+info.Key+".", seen)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, nested...)
+	}
+	return fields, nil
+}
@@ -33,6 +33,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"net/url"
 	"reflect"
 	"strings"
@@ -616,6 +617,65 @@ func (s *S) TestMarshalErrorItems(c *C) {
 	}
 }
 
+// --------------------------------------------------------------------------
+// Encrypted fields.
+
+type encryptedAddress struct {
+	Street string `bson:"street,encrypt=random,key=addr"`
+	City   string `bson:"city"`
+}
+
+type encryptedInline struct {
+	Phone string `bson:"phone,omitempty,encrypt=deterministic,key=phone"`
+}
+
+type encryptedPerson struct {
+	Name    string            `bson:"name"`
+	SSN     string            `bson:"ssn,encrypt=deterministic,key=ssn"`
+	Address *encryptedAddress `bson:"address"`
+	Billing encryptedAddress  `bson:"billing,encrypt=random,key=billing"`
+	Created time.Time         `bson:"created"`
+	Inline  encryptedInline   `bson:",inline"`
+	Next    *encryptedPerson  `bson:"next,omitempty"`
+}
+
+func (s *S) TestEncryptedFields(c *C) {
+	fields, err := bson.EncryptedFields(reflect.TypeOf(&encryptedPerson{}))
+	c.Assert(err, IsNil)
+	c.Assert(fields, DeepEquals, []bson.EncryptedField{
+		{Path: "ssn", Algorithm: "deterministic", KeyName: "ssn"},
+		{Path: "address.street", Algorithm: "random", KeyName: "addr"},
+		{Path: "billing", Algorithm: "random", KeyName: "billing"},
+		{Path: "phone", Algorithm: "deterministic", KeyName: "phone"},
+	})
+
+	fields, err = bson.EncryptedFields(reflect.TypeOf(bson.M{}))
+	c.Assert(err, IsNil)
+	c.Assert(fields, HasLen, 0)
+
+	// The flags don't affect marshalling itself.
+	data, err := bson.Marshal(&encryptedInline{"555"})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, wrapInDoc("\x02phone\x00\x04\x00\x00\x00555\x00"))
+
+	type noKey struct {
+		A string "a,encrypt=random"
+	}
+	type badAlgorithm struct {
+		A string "a,encrypt=aes,key=k"
+	}
+	c.Assert(marshalPanic(&noKey{}), Matches,
+		`Flags encrypt and key must be used together in tag "a,encrypt=random" of type .*`)
+	c.Assert(marshalPanic(&badAlgorithm{}), Matches,
+		`Unsupported flag "encrypt=aes" in tag "a,encrypt=aes,key=k" of type .*`)
+}
+
+func marshalPanic(in interface{}) (msg string) {
+	defer func() { msg = fmt.Sprint(recover()) }()
+	bson.Marshal(in)
+	return ""
+}
+
 // --------------------------------------------------------------------------
 // Unmarshalling error cases.
 
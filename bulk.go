@@ -25,6 +25,7 @@ type Bulk struct {
 	c       *Collection
 	opcount int
 	actions []bulkAction
+	models  map[int]WriteModel
 	ordered bool
 }
 
@@ -51,6 +52,14 @@ type BulkResult struct {
 	Matched  int
 	Modified int // Available only for MongoDB 2.6+
 
+	InsertedCount int
+	DeletedCount  int
+	UpsertedCount int
+
+	// UpsertedIds maps the position of each operation that resulted
+	// in an upsert to the _id of the inserted document.
+	UpsertedIds map[int]interface{}
+
 	// Be conservative while we understand exactly how to report these
 	// results in a useful and convenient way, and also how to emulate
 	// them with prior servers.
@@ -61,10 +70,14 @@ type BulkResult struct {
 // Individual errors may be obtained and inspected via the Cases method.
 type BulkError struct {
 	ecases []BulkErrorCase
+	wcerr  *LastError
 }
 
 func (e *BulkError) Error() string {
 	if len(e.ecases) == 0 {
+		if e.wcerr != nil {
+			return e.wcerr.Error()
+		}
 		return "invalid BulkError instance: no errors"
 	}
 	if len(e.ecases) == 1 {
@@ -109,6 +122,10 @@ func (slice bulkErrorCases) Swap(i, j int)      { slice[i], slice[j] = slice[j],
 type BulkErrorCase struct {
 	Index int // Position of operation that failed, or -1 if unknown.
 	Err   error
+
+	// Model holds the operation that failed when it was queued
+	// as a WriteModel, and is nil otherwise.
+	Model WriteModel
 }
 
 // Cases returns all individual errors found while attempting the requested changes.
@@ -118,6 +135,17 @@ func (e *BulkError) Cases() []BulkErrorCase {
 	return e.ecases
 }
 
+// WriteConcernError returns the error reported when the server failed to
+// satisfy the write concern requested for the bulk operation, or nil if
+// no such error was observed. Such an error does not imply that the
+// individual operations failed, and these are not reported as cases.
+func (e *BulkError) WriteConcernError() error {
+	if e.wcerr == nil {
+		return nil
+	}
+	return e.wcerr
+}
+
 // Bulk returns a value to prepare the execution of a bulk operation.
 func (c *Collection) Bulk() *Bulk {
 	return &Bulk{c: c, ordered: true}
@@ -265,12 +293,131 @@ func (b *Bulk) Upsert(pairs ...interface{}) {
 	}
 }
 
+// WriteModel is implemented by the operations that may be provided to
+// Bulk.Write and Collection.BulkWrite. These are InsertOneModel,
+// UpdateOneModel, UpdateManyModel, ReplaceOneModel, DeleteOneModel,
+// and DeleteManyModel.
+type WriteModel interface {
+	queue(b *Bulk)
+}
+
+// InsertOneModel inserts Document.
+type InsertOneModel struct {
+	Document interface{}
+}
+
+// UpdateOneModel modifies a single document matching Selector according
+// to Update, or inserts a new one if none matches and Upsert is true.
+type UpdateOneModel struct {
+	Selector interface{}
+	Update   interface{}
+	Upsert   bool
+}
+
+// UpdateManyModel modifies all documents matching Selector according
+// to Update, or inserts a new one if none matches and Upsert is true.
+type UpdateManyModel struct {
+	Selector interface{}
+	Update   interface{}
+	Upsert   bool
+}
+
+// ReplaceOneModel replaces a single document matching Selector with
+// Replacement, or inserts it if none matches and Upsert is true.
+type ReplaceOneModel struct {
+	Selector    interface{}
+	Replacement interface{}
+	Upsert      bool
+}
+
+// DeleteOneModel removes a single document matching Selector.
+type DeleteOneModel struct {
+	Selector interface{}
+}
+
+// DeleteManyModel removes all documents matching Selector.
+type DeleteManyModel struct {
+	Selector interface{}
+}
+
+func (m InsertOneModel) queue(b *Bulk) {
+	b.Insert(m.Document)
+}
+
+func (m UpdateOneModel) queue(b *Bulk) {
+	if m.Upsert {
+		b.Upsert(m.Selector, m.Update)
+	} else {
+		b.Update(m.Selector, m.Update)
+	}
+}
+
+func (m UpdateManyModel) queue(b *Bulk) {
+	if !m.Upsert {
+		b.UpdateAll(m.Selector, m.Update)
+		return
+	}
+	selector := m.Selector
+	if selector == nil {
+		selector = bson.D{}
+	}
+	action := b.action(bulkUpdate, 1)
+	action.docs = append(action.docs, &updateOp{
+		Collection: b.c.FullName,
+		Selector:   selector,
+		Update:     m.Update,
+		Flags:      3,
+		Multi:      true,
+		Upsert:     true,
+	})
+}
+
+func (m ReplaceOneModel) queue(b *Bulk) {
+	if m.Upsert {
+		b.Upsert(m.Selector, m.Replacement)
+	} else {
+		b.Update(m.Selector, m.Replacement)
+	}
+}
+
+func (m DeleteOneModel) queue(b *Bulk) {
+	b.Remove(m.Selector)
+}
+
+func (m DeleteManyModel) queue(b *Bulk) {
+	b.RemoveAll(m.Selector)
+}
+
+// Write queues up the provided operations. Errors reported for these
+// operations by Run refer back to the respective model.
+func (b *Bulk) Write(models ...WriteModel) {
+	for _, model := range models {
+		if b.models == nil {
+			b.models = make(map[int]WriteModel)
+		}
+		b.models[b.opcount] = model
+		model.queue(b)
+	}
+}
+
+// BulkWrite runs the provided operations in order as a single bulk
+// operation, stopping at the first error. See Bulk.Run for details.
+func (c *Collection) BulkWrite(models ...WriteModel) (*BulkResult, error) {
+	b := c.Bulk()
+	b.Write(models...)
+	return b.Run()
+}
+
 // Run runs all the operations queued up.
 //
 // If an error is reported on an unordered bulk operation, the error value may
 // be an aggregation of all issues observed. As an exception to that, Insert
 // operations running on MongoDB versions prior to 2.6 will report the last
 // error only due to a limitation in the wire protocol.
+//
+// When an error is reported, the result of the operations that ran is
+// returned along with it. Write concern errors don't stop ordered bulk
+// operations, as the writes they refer to were applied.
 func (b *Bulk) Run() (*BulkResult, error) {
 	var result BulkResult
 	var berr BulkError
@@ -295,9 +442,9 @@ func (b *Bulk) Run() (*BulkResult, error) {
 			}
 		}
 	}
-	if failed {
+	if failed || berr.wcerr != nil {
 		sort.Sort(bulkErrorCases(berr.ecases))
-		return nil, &berr
+		return &result, &berr
 	}
 	return &result, nil
 }
@@ -308,6 +455,15 @@ func (b *Bulk) runInsert(action *bulkAction, result *BulkResult, berr *BulkError
 		op.flags = 1 // ContinueOnError
 	}
 	lerr, err := b.c.writeOp(op, b.ordered)
+	if lerr != nil {
+		if err == nil {
+			result.InsertedCount += len(action.docs)
+		} else {
+			// Servers prior to 2.6 do not report how many documents
+			// were inserted before the failure.
+			result.InsertedCount += lerr.N
+		}
+	}
 	return b.checkSuccess(action, berr, lerr, err)
 }
 
@@ -316,6 +472,13 @@ func (b *Bulk) runUpdate(action *bulkAction, result *BulkResult, berr *BulkError
 	if lerr != nil {
 		result.Matched += lerr.N
 		result.Modified += lerr.modified
+		result.UpsertedCount += len(lerr.upserted)
+		for _, upserted := range lerr.upserted {
+			if result.UpsertedIds == nil {
+				result.UpsertedIds = make(map[int]interface{})
+			}
+			result.UpsertedIds[action.idxs[upserted.Index]] = upserted.Id
+		}
 	}
 	return b.checkSuccess(action, berr, lerr, err)
 }
@@ -325,11 +488,15 @@ func (b *Bulk) runRemove(action *bulkAction, result *BulkResult, berr *BulkError
 	if lerr != nil {
 		result.Matched += lerr.N
 		result.Modified += lerr.modified
+		result.DeletedCount += lerr.N
 	}
 	return b.checkSuccess(action, berr, lerr, err)
 }
 
 func (b *Bulk) checkSuccess(action *bulkAction, berr *BulkError, lerr *LastError, err error) bool {
+	if lerr != nil && lerr.wcerr != nil && berr.wcerr == nil {
+		berr.wcerr = lerr.wcerr
+	}
 	if lerr != nil && len(lerr.ecases) > 0 {
 		for i := 0; i < len(lerr.ecases); i++ {
 			// Map back from the local error index into the visible one.
@@ -338,12 +505,16 @@ func (b *Bulk) checkSuccess(action *bulkAction, berr *BulkError, lerr *LastError
 			if idx >= 0 {
 				idx = action.idxs[idx]
 			}
-			berr.ecases = append(berr.ecases, BulkErrorCase{idx, ecase.Err})
+			berr.ecases = append(berr.ecases, BulkErrorCase{Index: idx, Err: ecase.Err, Model: b.models[idx]})
 		}
 		return false
+	} else if lerr != nil && lerr.wcerr != nil {
+		// Reported by Run once all operations are done.
+		return true
 	} else if err != nil {
 		for i := 0; i < len(action.idxs); i++ {
-			berr.ecases = append(berr.ecases, BulkErrorCase{action.idxs[i], err})
+			idx := action.idxs[i]
+			berr.ecases = append(berr.ecases, BulkErrorCase{Index: idx, Err: err, Model: b.models[idx]})
 		}
 		return false
 	}
@@ -29,6 +29,7 @@ package mgo_test
 import (
 	. "gopkg.in/check.v1"
 	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
 )
 
 func (s *S) TestBulkInsert(c *C) {
@@ -131,6 +132,118 @@ func (s *S) TestBulkInsertErrorUnorderedSplitBatch(c *C) {
 	c.Assert(res.Id, Equals, 1500)
 }
 
+func (s *S) TestBulkInsertWriteConcernErrorSplitBatch(c *C) {
+	if !s.versionAtLeast(4, 0) {
+		c.Skip("4.0+ is needed to inject write concern errors")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	// Only the first of the split batches gets a write concern error.
+	admindb := session.DB("admin")
+	err = admindb.Run(bson.D{
+		{"configureFailPoint", "failCommand"},
+		{"mode", M{"times": 1}},
+		{"data", M{
+			"failCommands":      []string{"insert"},
+			"writeConcernError": M{"code": 100, "errmsg": "injected write concern error"},
+		}},
+	}, nil)
+	c.Assert(err, IsNil)
+	defer admindb.Run(bson.D{{"configureFailPoint", "failCommand"}, {"mode", "off"}}, nil)
+
+	coll := session.DB("mydb").C("mycoll")
+	bulk := coll.Bulk()
+
+	const total = 2500
+	type doc struct {
+		Id int `_id`
+	}
+	docs := make([]interface{}, total)
+	for i := 0; i < total; i++ {
+		docs[i] = doc{i}
+	}
+	bulk.Insert(docs...)
+	_, err = bulk.Run()
+	c.Assert(err, ErrorMatches, "injected write concern error")
+	berr := err.(*mgo.BulkError)
+	c.Assert(berr.Cases(), HasLen, 0)
+	c.Assert(berr.WriteConcernError(), ErrorMatches, "injected write concern error")
+
+	// The batches following the write concern error were sent.
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, total)
+}
+
+func (s *S) TestBulkInsertSplitBatchBySize(c *C) {
+	if !s.versionAtLeast(2, 6) {
+		c.Skip("2.4- has no write commands")
+	}
+	// Documents must also be split so that each command fits within
+	// the maximum document size accepted by the server.
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	bulk := coll.Bulk()
+	bulk.Unordered()
+
+	const total = 40
+	type doc struct {
+		Id   int `_id`
+		Data []byte
+	}
+	data := make([]byte, 1024*1024)
+	docs := make([]interface{}, total)
+	for i := 0; i < total; i++ {
+		docs[i] = doc{i, data}
+	}
+	docs[35] = doc{2, data}
+	bulk.Insert(docs...)
+	_, err = bulk.Run()
+	c.Assert(err, ErrorMatches, ".*duplicate key.*")
+
+	ecases := err.(*mgo.BulkError).Cases()
+	c.Assert(ecases, HasLen, 1)
+	c.Assert(ecases[0].Index, Equals, 35)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, total-1)
+}
+
+func (s *S) TestBulkInsertSplitBatchBySizeOrdered(c *C) {
+	if !s.versionAtLeast(2, 6) {
+		c.Skip("2.4- has no write commands")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	const total = 40
+	type doc struct {
+		Id   int `_id`
+		Data []byte
+	}
+	data := make([]byte, 1024*1024)
+	docs := make([]interface{}, total)
+	for i := 0; i < total; i++ {
+		docs[i] = doc{i, data}
+	}
+	docs[25] = doc{2, data}
+	err = coll.Insert(docs...)
+	c.Assert(mgo.IsDup(err), Equals, true)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 25)
+}
+
 func (s *S) TestBulkErrorString(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -502,3 +615,71 @@ func (s *S) TestBulkRemoveAll(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(res, DeepEquals, []doc{{3}})
 }
+
+func (s *S) TestBulkWrite(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"n": 1}, M{"n": 2}, M{"n": 3}, M{"n": 4})
+	c.Assert(err, IsNil)
+
+	r, err := coll.BulkWrite(
+		mgo.InsertOneModel{M{"n": 5}},
+		mgo.UpdateOneModel{Selector: M{"n": 1}, Update: M{"$set": M{"n": 10}}},
+		mgo.DeleteOneModel{M{"n": 10}},
+		mgo.UpdateManyModel{Selector: M{"n": M{"$gt": 3}}, Update: M{"$inc": M{"n": 10}}},
+		mgo.ReplaceOneModel{Selector: M{"n": 6}, Replacement: M{"n": 60}, Upsert: true},
+		mgo.UpdateOneModel{Selector: M{"n": 7}, Update: M{"$set": M{"n": 70}}, Upsert: true},
+		mgo.DeleteManyModel{M{"n": M{"$lt": 3}}},
+	)
+	c.Assert(err, IsNil)
+	c.Assert(r.InsertedCount, Equals, 1)
+	c.Assert(r.Matched, Equals, 7)
+	c.Assert(r.DeletedCount, Equals, 2)
+	c.Assert(r.UpsertedCount, Equals, 2)
+	c.Assert(r.UpsertedIds, HasLen, 2)
+	c.Assert(r.UpsertedIds[4], NotNil)
+	c.Assert(r.UpsertedIds[5], NotNil)
+
+	type doc struct{ N int }
+	var res []doc
+	err = coll.Find(nil).Sort("n").All(&res)
+	c.Assert(err, IsNil)
+	c.Assert(res, DeepEquals, []doc{{3}, {14}, {15}, {60}, {70}})
+}
+
+func (s *S) TestBulkWriteError(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	dup := mgo.InsertOneModel{M{"_id": 1}}
+	r, err := coll.BulkWrite(
+		mgo.InsertOneModel{M{"_id": 1}},
+		mgo.UpdateOneModel{Selector: M{"_id": 1}, Update: M{"$set": M{"n": 1}}},
+		dup,
+		mgo.InsertOneModel{M{"_id": 2}},
+	)
+	c.Assert(err, ErrorMatches, ".*duplicate key.*")
+
+	ecases := err.(*mgo.BulkError).Cases()
+	c.Assert(ecases, HasLen, 1)
+	if s.versionAtLeast(2, 6) {
+		c.Assert(ecases[0].Index, Equals, 2)
+		c.Assert(ecases[0].Model, DeepEquals, dup)
+
+		// The result of what ran is returned with the error.
+		c.Assert(r.InsertedCount, Equals, 1)
+		c.Assert(r.Matched, Equals, 1)
+	}
+	c.Assert(err.(*mgo.BulkError).WriteConcernError(), IsNil)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
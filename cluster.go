@@ -138,6 +138,10 @@ type isMasterResult struct {
 	Msg            string
 	SetName        string `bson:"setName"`
 	MaxWireVersion int    `bson:"maxWireVersion"`
+
+	MaxBsonObjectSize   int `bson:"maxBsonObjectSize"`
+	MaxMessageSizeBytes int `bson:"maxMessageSizeBytes"`
+	MaxWriteBatchSize   int `bson:"maxWriteBatchSize"`
 }
 
 func (cluster *mongoCluster) isMaster(socket *mongoSocket, result *isMasterResult) error {
@@ -232,6 +236,19 @@ func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerI
 		Tags:           result.Tags,
 		SetName:        result.SetName,
 		MaxWireVersion: result.MaxWireVersion,
+
+		MaxBsonObjectSize:   result.MaxBsonObjectSize,
+		MaxMessageSizeBytes: result.MaxMessageSizeBytes,
+		MaxWriteBatchSize:   result.MaxWriteBatchSize,
+	}
+	if info.MaxBsonObjectSize == 0 {
+		info.MaxBsonObjectSize = defaultMaxBsonObjectSize
+	}
+	if info.MaxMessageSizeBytes == 0 {
+		info.MaxMessageSizeBytes = defaultMaxMessageSizeBytes
+	}
+	if info.MaxWriteBatchSize == 0 {
+		info.MaxWriteBatchSize = defaultMaxWriteBatchSize
 	}
 
 	hosts = make([]string, 0, 1+len(result.Hosts)+len(result.Passives))
@@ -653,6 +670,41 @@ func (cluster *mongoCluster) AcquireSocket(mode Mode, slaveOk bool, syncTimeout
 	panic("unreached")
 }
 
+// Server returns the cluster server with the given address. If the server
+// is not yet known, Server waits up to syncTimeout for the cluster to
+// synchronize and find it before giving up.
+func (cluster *mongoCluster) Server(addr string, syncTimeout time.Duration) (*mongoServer, error) {
+	var started time.Time
+	var syncCount uint
+	cluster.RLock()
+	defer cluster.RUnlock()
+	for {
+		for _, server := range cluster.servers.Slice() {
+			if server.Addr == addr || server.ResolvedAddr == addr {
+				return server, nil
+			}
+		}
+		if started.IsZero() {
+			started = time.Now()
+			syncCount = cluster.syncCount
+		} else if syncTimeout != 0 && started.Before(time.Now().Add(-syncTimeout)) || cluster.failFast && cluster.syncCount != syncCount {
+			return nil, fmt.Errorf("server %s is not reachable", addr)
+		}
+		cluster.syncServers()
+
+		// Remember: this will release and reacquire the lock.
+		cluster.serverSynced.Wait()
+	}
+}
+
+// HasMongos returns whether the known masters of the cluster are
+// mongos routers.
+func (cluster *mongoCluster) HasMongos() bool {
+	cluster.RLock()
+	defer cluster.RUnlock()
+	return cluster.masters.HasMongos()
+}
+
 func (cluster *mongoCluster) CacheIndex(cacheKey string, exists bool) {
 	cluster.Lock()
 	if cluster.cachedIndex == nil {
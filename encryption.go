@@ -0,0 +1,828 @@
+package mgo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// Algorithms supported by ClientEncryption. Deterministic encryption always
+// produces the same ciphertext for the same value and data key, so encrypted
+// fields may still be queried for equality. Random encryption is safer and
+// should be used for fields that are not queried.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/csfle/fundamentals/encryption-algorithms/
+//
+const (
+	AEADDeterministic = "AEAD_AES_256_CBC_HMAC_SHA_512-Deterministic"
+	AEADRandom        = "AEAD_AES_256_CBC_HMAC_SHA_512-Random"
+)
+
+// BinaryEncrypted is the bson.Binary kind of encrypted values.
+const BinaryEncrypted = 0x06
+
+const (
+	fleDeterministic = 1
+	fleRandom        = 2
+
+	fleKeyLen    = 96
+	fleTagLen    = 32
+	fleHeaderLen = 18
+)
+
+var (
+	ErrDataKeyNotFound = errors.New("data key not found in the key vault")
+	errFLEDecrypt      = errors.New("encrypted value failed authentication")
+)
+
+// KMSProvider wraps the data keys of ClientEncryption with a master key that
+// is held by a key management service, so that data keys are never stored in
+// the clear.
+type KMSProvider interface {
+	// Name returns the name of the provider, such as "local". It's
+	// recorded in the masterKey field of the data keys it wraps.
+	Name() string
+
+	// WrapKey encrypts dataKey with the master key identified by the
+	// masterKey document, which also holds the provider name.
+	WrapKey(masterKey bson.M, dataKey []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key previously encrypted by WrapKey.
+	UnwrapKey(masterKey bson.M, wrapped []byte) ([]byte, error)
+}
+
+type localKMS struct {
+	key []byte
+}
+
+// NewLocalKMS returns a KMSProvider named "local" that wraps data keys
+// with the provided 96 bytes long master key. The master key must be
+// kept safe, since all data keys and thus all values encrypted with
+// them depend on it.
+func NewLocalKMS(masterKey []byte) (KMSProvider, error) {
+	if len(masterKey) != fleKeyLen {
+		return nil, fmt.Errorf("local master key must be %d bytes long, got %d", fleKeyLen, len(masterKey))
+	}
+	return &localKMS{append([]byte(nil), masterKey...)}, nil
+}
+
+func (kms *localKMS) Name() string {
+	return "local"
+}
+
+func (kms *localKMS) WrapKey(masterKey bson.M, dataKey []byte) ([]byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	return aeadEncrypt(kms.key, dataKey, nil, iv)
+}
+
+func (kms *localKMS) UnwrapKey(masterKey bson.M, wrapped []byte) ([]byte, error) {
+	return aeadDecrypt(kms.key, wrapped, nil)
+}
+
+// DataKey holds a data key document, as stored in the key vault.
+type DataKey struct {
+	Id           bson.Binary `bson:"_id"`
+	KeyAltNames  []string    `bson:"keyAltNames,omitempty"`
+	KeyMaterial  []byte      `bson:"keyMaterial"`
+	CreationDate time.Time   `bson:"creationDate"`
+	UpdateDate   time.Time   `bson:"updateDate"`
+	Status       int         `bson:"status"`
+	MasterKey    bson.M      `bson:"masterKey"`
+}
+
+// ClientEncryption encrypts and decrypts individual values on the client
+// side, so that the server only ever sees them encrypted. Values are
+// encrypted with data keys held in a key vault collection, which are in
+// turn wrapped by the master key of a KMSProvider. The encrypted values
+// are compatible with those produced by the explicit encryption support
+// of the official MongoDB drivers.
+//
+// For example:
+//
+//     kms, err := mgo.NewLocalKMS(masterKey)
+//     ...
+//     ce := mgo.NewClientEncryption(session.DB("encryption").C("__keyVault"), kms)
+//     keyId, err := ce.CreateDataKey("local", nil, "pii")
+//     ...
+//     ssn, err := ce.Encrypt("123-45-6789", keyId, mgo.AEADDeterministic)
+//     ...
+//     err = people.Insert(bson.M{"name": name, "ssn": ssn})
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/csfle/
+//
+type ClientEncryption struct {
+	keyVault  *Collection
+	providers map[string]KMSProvider
+
+	m    sync.Mutex
+	keys map[string][]byte
+}
+
+// NewClientEncryption returns a ClientEncryption holding its data keys
+// in the keyVault collection, wrapped by the provided KMS providers.
+func NewClientEncryption(keyVault *Collection, providers ...KMSProvider) *ClientEncryption {
+	ce := &ClientEncryption{
+		keyVault:  keyVault,
+		providers: make(map[string]KMSProvider),
+		keys:      make(map[string][]byte),
+	}
+	for _, provider := range providers {
+		ce.providers[provider.Name()] = provider
+	}
+	return ce
+}
+
+// CreateDataKey creates a new data key wrapped by the named provider and
+// stores it in the key vault, returning its id. The masterKey document
+// holds provider specific details about the master key to use, and may
+// be nil for the "local" provider. The key may also be referred to by
+// the optional altNames.
+func (ce *ClientEncryption) CreateDataKey(provider string, masterKey bson.M, altNames ...string) (keyId bson.Binary, err error) {
+	kms, ok := ce.providers[provider]
+	if !ok {
+		return keyId, fmt.Errorf("unknown KMS provider: %q", provider)
+	}
+	doc := bson.M{"provider": provider}
+	for name, value := range masterKey {
+		doc[name] = value
+	}
+	dataKey := make([]byte, fleKeyLen)
+	if _, err = rand.Read(dataKey); err != nil {
+		return keyId, err
+	}
+	wrapped, err := kms.WrapKey(doc, dataKey)
+	if err != nil {
+		return keyId, err
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return keyId, err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40 // Version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // Variant RFC 4122
+	now := time.Now()
+	key := &DataKey{
+		Id:           bson.Binary{Kind: 0x04, Data: id},
+		KeyAltNames:  altNames,
+		KeyMaterial:  wrapped,
+		CreationDate: now,
+		UpdateDate:   now,
+		MasterKey:    doc,
+	}
+	if err = ce.keyVault.Insert(key); err != nil {
+		return keyId, err
+	}
+	ce.m.Lock()
+	ce.keys[string(id)] = dataKey
+	ce.m.Unlock()
+	return key.Id, nil
+}
+
+// DataKeyId returns the id of the data key with the provided
+// alternate name.
+func (ce *ClientEncryption) DataKeyId(altName string) (keyId bson.Binary, err error) {
+	var key DataKey
+	err = ce.keyVault.Find(bson.M{"keyAltNames": altName}).Select(bson.M{"_id": 1}).One(&key)
+	if err == ErrNotFound {
+		err = ErrDataKeyNotFound
+	}
+	return key.Id, err
+}
+
+// dataKey returns the unwrapped data key with the provided id.
+func (ce *ClientEncryption) dataKey(id []byte) ([]byte, error) {
+	ce.m.Lock()
+	dataKey, ok := ce.keys[string(id)]
+	ce.m.Unlock()
+	if ok {
+		return dataKey, nil
+	}
+	var key DataKey
+	err := ce.keyVault.FindId(bson.Binary{Kind: 0x04, Data: id}).One(&key)
+	if err == ErrNotFound {
+		return nil, ErrDataKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider, _ := key.MasterKey["provider"].(string)
+	kms, ok := ce.providers[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown KMS provider: %q", provider)
+	}
+	dataKey, err = kms.UnwrapKey(key.MasterKey, key.KeyMaterial)
+	if err != nil {
+		return nil, err
+	}
+	if len(dataKey) != fleKeyLen {
+		return nil, fmt.Errorf("data key must be %d bytes long, got %d", fleKeyLen, len(dataKey))
+	}
+	ce.m.Lock()
+	ce.keys[string(id)] = dataKey
+	ce.m.Unlock()
+	return dataKey, nil
+}
+
+// Encrypt encrypts value with the data key identified by keyId, using
+// one of the AEADDeterministic or AEADRandom algorithms. The resulting
+// value may be stored in place of the original one, and is recovered
+// via Decrypt.
+func (ce *ClientEncryption) Encrypt(value interface{}, keyId bson.Binary, algorithm string) (bson.Binary, error) {
+	if len(keyId.Data) != 16 {
+		return bson.Binary{}, errors.New("data key id must be a 16 bytes long UUID")
+	}
+	dataKey, err := ce.dataKey(keyId.Data)
+	if err != nil {
+		return bson.Binary{}, err
+	}
+	return fleEncrypt(dataKey, keyId.Data, value, algorithm)
+}
+
+// Decrypt returns the original value of an encrypted value produced by
+// Encrypt, as it would be unmarshalled into an interface{} value.
+func (ce *ClientEncryption) Decrypt(bin bson.Binary) (interface{}, error) {
+	raw, err := ce.DecryptRaw(bin)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := raw.Unmarshal(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// DecryptRaw returns the original value of an encrypted value produced
+// by Encrypt, for unmarshalling into a value of an appropriate type.
+func (ce *ClientEncryption) DecryptRaw(bin bson.Binary) (bson.Raw, error) {
+	if bin.Kind != BinaryEncrypted || len(bin.Data) < fleHeaderLen {
+		return bson.Raw{}, errors.New("value is not encrypted")
+	}
+	dataKey, err := ce.dataKey(bin.Data[1:17])
+	if err != nil {
+		return bson.Raw{}, err
+	}
+	return fleDecrypt(dataKey, bin)
+}
+
+// fleEncrypt returns value encrypted with dataKey, identified by keyId.
+// The payload holds the algorithm, the key id and the BSON type of the
+// value, followed by the ciphertext of the BSON encoded value.
+func fleEncrypt(dataKey, keyId []byte, value interface{}, algorithm string) (bson.Binary, error) {
+	var subtype byte
+	switch algorithm {
+	case AEADDeterministic:
+		subtype = fleDeterministic
+	case AEADRandom:
+		subtype = fleRandom
+	default:
+		return bson.Binary{}, fmt.Errorf("unsupported encryption algorithm: %q", algorithm)
+	}
+	data, err := bson.Marshal(bson.D{{"v", value}})
+	if err != nil {
+		return bson.Binary{}, err
+	}
+	kind, plaintext := data[4], data[7:len(data)-1]
+	switch kind {
+	case 0x06, 0x0A, 0x7F, 0xFF:
+		return bson.Binary{}, fmt.Errorf("cannot encrypt values of BSON type 0x%02x", kind)
+	case 0x01, 0x03, 0x04, 0x08, 0x0F, 0x13:
+		if subtype == fleDeterministic {
+			return bson.Binary{}, fmt.Errorf("cannot encrypt values of BSON type 0x%02x deterministically", kind)
+		}
+	}
+
+	header := make([]byte, fleHeaderLen)
+	header[0] = subtype
+	copy(header[1:17], keyId)
+	header[17] = kind
+
+	iv := make([]byte, aes.BlockSize)
+	if subtype == fleDeterministic {
+		mac := hmac.New(sha512.New, dataKey[64:96])
+		mac.Write(header)
+		mac.Write(aadBits(header))
+		mac.Write(plaintext)
+		copy(iv, mac.Sum(nil))
+	} else if _, err := rand.Read(iv); err != nil {
+		return bson.Binary{}, err
+	}
+	ciphertext, err := aeadEncrypt(dataKey, plaintext, header, iv)
+	if err != nil {
+		return bson.Binary{}, err
+	}
+	return bson.Binary{Kind: BinaryEncrypted, Data: append(header, ciphertext...)}, nil
+}
+
+// fleDecrypt returns the original value of bin, encrypted with dataKey.
+func fleDecrypt(dataKey []byte, bin bson.Binary) (bson.Raw, error) {
+	header := bin.Data[:fleHeaderLen]
+	if header[0] != fleDeterministic && header[0] != fleRandom {
+		return bson.Raw{}, fmt.Errorf("unsupported encrypted value subtype: %d", header[0])
+	}
+	plaintext, err := aeadDecrypt(dataKey, bin.Data[fleHeaderLen:], header)
+	if err != nil {
+		return bson.Raw{}, err
+	}
+	return bson.Raw{Kind: header[17], Data: plaintext}, nil
+}
+
+// aeadEncrypt implements AEAD_AES_256_CBC_HMAC_SHA_512 as used by MongoDB,
+// with the first 32 bytes of key used for authentication, the following
+// 32 bytes for encryption, and the last 32 bytes for deriving the iv of
+// deterministic encryption.
+func aeadEncrypt(key, plaintext, aad, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key[32:64])
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte(nil), plaintext...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, aes.BlockSize+len(padded), aes.BlockSize+len(padded)+fleTagLen)
+	copy(out, iv)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[aes.BlockSize:], padded)
+	return append(out, aeadTag(key, aad, out)...), nil
+}
+
+func aeadDecrypt(key, data, aad []byte) ([]byte, error) {
+	if len(data) < 2*aes.BlockSize+fleTagLen || (len(data)-fleTagLen)%aes.BlockSize != 0 {
+		return nil, errFLEDecrypt
+	}
+	ciphertext, tag := data[:len(data)-fleTagLen], data[len(data)-fleTagLen:]
+	if !hmac.Equal(tag, aeadTag(key, aad, ciphertext)) {
+		return nil, errFLEDecrypt
+	}
+	block, err := aes.NewCipher(key[32:64])
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext[aes.BlockSize:])
+	pad := int(plaintext[len(plaintext)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errFLEDecrypt
+	}
+	return plaintext[:len(plaintext)-pad], nil
+}
+
+func aeadTag(key, aad, ciphertext []byte) []byte {
+	mac := hmac.New(sha512.New, key[:32])
+	mac.Write(aad)
+	mac.Write(ciphertext)
+	mac.Write(aadBits(aad))
+	return mac.Sum(nil)[:fleTagLen]
+}
+
+// aadBits returns the length of aad in bits as a big-endian uint64.
+func aadBits(aad []byte) []byte {
+	bits := make([]byte, 8)
+	binary.BigEndian.PutUint64(bits, uint64(len(aad))*8)
+	return bits
+}
+
+// AutoEncryption holds the settings for encrypting and decrypting
+// document fields transparently. See Session.SetAutoEncryption.
+type AutoEncryption struct {
+	// Client performs the actual encryption and decryption of values.
+	Client *ClientEncryption
+
+	// KeyId returns the id of the data key with the name provided in
+	// the key flag of encrypted fields. If nil, the name is looked up
+	// as an alternate key name in the key vault of Client.
+	KeyId func(name string) (bson.Binary, error)
+
+	// Schemas maps collection names, such as "mydb.people", to values of
+	// struct types whose tags describe the encrypted fields of documents
+	// in the collection. Schemas are necessary for encrypting the operands
+	// of queries and update operators, and documents that are not of a
+	// tagged struct type, as those carry no tags of their own.
+	Schemas map[string]interface{}
+}
+
+// SetAutoEncryption enables the transparent encryption of the fields
+// tagged with the encrypt and key flags (see bson.Marshal) in documents
+// written via the session, and the transparent decryption of all the
+// encrypted values in documents read via the session. Providing nil
+// disables it. Sessions created via Copy or Clone inherit the setting.
+//
+// For example:
+//
+//     type Person struct {
+//         Name string `bson:"name"`
+//         SSN  string `bson:"ssn,encrypt=deterministic,key=ssn"`
+//     }
+//
+//     err := session.SetAutoEncryption(&mgo.AutoEncryption{
+//         Client:  ce,
+//         Schemas: map[string]interface{}{"mydb.people": Person{}},
+//     })
+//     ...
+//     err = people.Insert(&Person{"Ann", "123-45-6789"})
+//     ...
+//     err = people.Find(bson.M{"ssn": "123-45-6789"}).One(&person)
+//
+// Encrypted fields may only be queried for equality, via plain values
+// or the $eq, $ne, $in and $nin operators, and only if encrypted with
+// the deterministic algorithm. The fields set by $set and $setOnInsert
+// are encrypted, while other update operators are left untouched.
+// Null values are never encrypted.
+func (s *Session) SetAutoEncryption(settings *AutoEncryption) error {
+	var ae *autoEncrypter
+	if settings != nil {
+		if settings.Client == nil {
+			return errors.New("auto encryption requires a ClientEncryption")
+		}
+		ae = &autoEncrypter{
+			client:  settings.Client,
+			keyId:   settings.KeyId,
+			schemas: make(map[string]fleSchema),
+			types:   make(map[reflect.Type]fleSchema),
+			keyIds:  make(map[string]bson.Binary),
+		}
+		if ae.keyId == nil {
+			ae.keyId = settings.Client.DataKeyId
+		}
+		for ns, value := range settings.Schemas {
+			schema, err := ae.typeSchema(reflect.TypeOf(value))
+			if err != nil {
+				return err
+			}
+			ae.schemas[ns] = schema
+		}
+	}
+	s.m.Lock()
+	s.autoEncryption = ae
+	s.m.Unlock()
+	return nil
+}
+
+func (s *Session) encrypter() *autoEncrypter {
+	s.m.RLock()
+	ae := s.autoEncryption
+	s.m.RUnlock()
+	return ae
+}
+
+// fleSchema maps the dotted paths of encrypted fields to their details.
+type fleSchema map[string]bson.EncryptedField
+
+// hasPrefix returns whether any encrypted field is nested under prefix.
+func (schema fleSchema) hasPrefix(prefix string) bool {
+	for path := range schema {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type autoEncrypter struct {
+	client  *ClientEncryption
+	keyId   func(name string) (bson.Binary, error)
+	schemas map[string]fleSchema
+
+	m      sync.Mutex
+	types  map[reflect.Type]fleSchema
+	keyIds map[string]bson.Binary
+}
+
+// typeSchema returns the schema described by the tags of struct type t.
+func (ae *autoEncrypter) typeSchema(t reflect.Type) (fleSchema, error) {
+	ae.m.Lock()
+	schema, ok := ae.types[t]
+	ae.m.Unlock()
+	if ok {
+		return schema, nil
+	}
+	fields, err := bson.EncryptedFields(t)
+	if err != nil {
+		return nil, err
+	}
+	schema = make(fleSchema)
+	for _, field := range fields {
+		schema[field.Path] = field
+	}
+	ae.m.Lock()
+	ae.types[t] = schema
+	ae.m.Unlock()
+	return schema, nil
+}
+
+// docSchema returns the schema of doc, as described by its own tags
+// if it's a tagged struct, or the schema of the ns collection otherwise.
+func (ae *autoEncrypter) docSchema(ns string, doc interface{}) (fleSchema, error) {
+	if t := reflect.TypeOf(doc); t != nil {
+		schema, err := ae.typeSchema(t)
+		if err != nil || len(schema) > 0 {
+			return schema, err
+		}
+	}
+	return ae.schemas[ns], nil
+}
+
+// encrypt returns the value of raw encrypted as defined by field.
+func (ae *autoEncrypter) encrypt(raw bson.Raw, field bson.EncryptedField) (interface{}, error) {
+	if raw.Kind == 0x0A || raw.Kind == 0x05 && len(raw.Data) > 4 && raw.Data[4] == BinaryEncrypted {
+		return raw, nil
+	}
+	ae.m.Lock()
+	keyId, ok := ae.keyIds[field.KeyName]
+	ae.m.Unlock()
+	if !ok {
+		var err error
+		keyId, err = ae.keyId(field.KeyName)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve data key %q: %v", field.KeyName, err)
+		}
+		ae.m.Lock()
+		ae.keyIds[field.KeyName] = keyId
+		ae.m.Unlock()
+	}
+	algorithm := AEADRandom
+	if field.Algorithm == "deterministic" {
+		algorithm = AEADDeterministic
+	}
+	bin, err := ae.client.Encrypt(raw, keyId, algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt field %q: %v", field.Path, err)
+	}
+	return bin, nil
+}
+
+// encryptDoc returns doc with its encrypted fields encrypted.
+func (ae *autoEncrypter) encryptDoc(ns string, doc interface{}) (interface{}, error) {
+	schema, err := ae.docSchema(ns, doc)
+	if err != nil || len(schema) == 0 {
+		return doc, err
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return ae.encryptFields(data, "", schema)
+}
+
+func (ae *autoEncrypter) encryptFields(data []byte, prefix string, schema fleSchema) (bson.D, error) {
+	var doc bson.RawD
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	out := make(bson.D, len(doc))
+	for i, elem := range doc {
+		path := prefix + elem.Name
+		out[i] = bson.DocElem{elem.Name, elem.Value}
+		var err error
+		if field, ok := schema[path]; ok {
+			out[i].Value, err = ae.encrypt(elem.Value, field)
+		} else if elem.Value.Kind == 0x03 && schema.hasPrefix(path+".") {
+			out[i].Value, err = ae.encryptFields(elem.Value.Data, path+".", schema)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// encryptUpdate returns the update document with its encrypted fields
+// encrypted, including those set via the $set and $setOnInsert operators.
+func (ae *autoEncrypter) encryptUpdate(ns string, update interface{}) (interface{}, error) {
+	if update == nil {
+		return nil, nil
+	}
+	schema, err := ae.docSchema(ns, update)
+	if err != nil || len(schema) == 0 {
+		return update, err
+	}
+	data, err := bson.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.RawD
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 || !strings.HasPrefix(doc[0].Name, "$") {
+		return ae.encryptFields(data, "", schema)
+	}
+	out := make(bson.D, len(doc))
+	for i, elem := range doc {
+		out[i] = bson.DocElem{elem.Name, elem.Value}
+		if (elem.Name == "$set" || elem.Name == "$setOnInsert") && elem.Value.Kind == 0x03 {
+			out[i].Value, err = ae.encryptFields(elem.Value.Data, "", schema)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// encryptQuery returns the query document with the equality operands
+// on encrypted fields encrypted, according to the schema of ns.
+func (ae *autoEncrypter) encryptQuery(ns string, query interface{}) (interface{}, error) {
+	schema := ae.schemas[ns]
+	if len(schema) == 0 || query == nil {
+		return query, nil
+	}
+	data, err := bson.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	return ae.encryptFilter(data, schema)
+}
+
+func (ae *autoEncrypter) encryptFilter(data []byte, schema fleSchema) (bson.D, error) {
+	var doc bson.RawD
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	out := make(bson.D, len(doc))
+	for i, elem := range doc {
+		out[i] = bson.DocElem{elem.Name, elem.Value}
+		var err error
+		switch elem.Name {
+		case "$and", "$or", "$nor":
+			var clauses []bson.Raw
+			if err := elem.Value.Unmarshal(&clauses); err != nil {
+				return nil, err
+			}
+			list := make([]interface{}, len(clauses))
+			for j, clause := range clauses {
+				if list[j], err = ae.encryptFilter(clause.Data, schema); err != nil {
+					return nil, err
+				}
+			}
+			out[i].Value = list
+			continue
+		}
+		field, ok := schema[elem.Name]
+		if !ok {
+			continue
+		}
+		if field.Algorithm != "deterministic" {
+			return nil, fmt.Errorf("cannot query field %q encrypted with the random algorithm", field.Path)
+		}
+		if elem.Value.Kind != 0x03 || !isOperatorDoc(elem.Value.Data) {
+			if out[i].Value, err = ae.encrypt(elem.Value, field); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var ops bson.RawD
+		if err := bson.Unmarshal(elem.Value.Data, &ops); err != nil {
+			return nil, err
+		}
+		cond := make(bson.D, len(ops))
+		for j, op := range ops {
+			cond[j] = bson.DocElem{op.Name, op.Value}
+			switch op.Name {
+			case "$eq", "$ne":
+				cond[j].Value, err = ae.encrypt(op.Value, field)
+			case "$in", "$nin":
+				var values []bson.Raw
+				if err := op.Value.Unmarshal(&values); err != nil {
+					return nil, err
+				}
+				list := make([]interface{}, len(values))
+				for k, value := range values {
+					if list[k], err = ae.encrypt(value, field); err != nil {
+						break
+					}
+				}
+				cond[j].Value = list
+			case "$exists":
+			default:
+				err = fmt.Errorf("cannot use %s on field %q, as it's encrypted", op.Name, field.Path)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		out[i].Value = cond
+	}
+	return out, nil
+}
+
+// isOperatorDoc returns whether the first key of the document
+// in data is a query operator, such as $eq.
+func isOperatorDoc(data []byte) bool {
+	// Skip the document length and the element kind.
+	return len(data) > 6 && data[5] == '$'
+}
+
+// encryptOp returns a copy of the write operation op with
+// its documents and selectors encrypted.
+func (ae *autoEncrypter) encryptOp(op interface{}) (interface{}, error) {
+	var err error
+	switch op := op.(type) {
+	case *insertOp:
+		docs := make([]interface{}, len(op.documents))
+		for i, doc := range op.documents {
+			if docs[i], err = ae.encryptDoc(op.collection, doc); err != nil {
+				return nil, err
+			}
+		}
+		return &insertOp{op.collection, docs, op.flags}, nil
+	case *updateOp:
+		update := *op
+		if update.Selector, err = ae.encryptQuery(op.Collection, op.Selector); err != nil {
+			return nil, err
+		}
+		if update.Update, err = ae.encryptUpdate(op.Collection, op.Update); err != nil {
+			return nil, err
+		}
+		return &update, nil
+	case *deleteOp:
+		remove := *op
+		if remove.Selector, err = ae.encryptQuery(op.Collection, op.Selector); err != nil {
+			return nil, err
+		}
+		return &remove, nil
+	case bulkUpdateOp:
+		ops := make(bulkUpdateOp, len(op))
+		for i := range op {
+			if ops[i], err = ae.encryptOp(op[i]); err != nil {
+				return nil, err
+			}
+		}
+		return ops, nil
+	case bulkDeleteOp:
+		ops := make(bulkDeleteOp, len(op))
+		for i := range op {
+			if ops[i], err = ae.encryptOp(op[i]); err != nil {
+				return nil, err
+			}
+		}
+		return ops, nil
+	}
+	return op, nil
+}
+
+// decryptDoc returns the document in data with all of its encrypted
+// values decrypted, or data itself if it holds no encrypted values.
+func (ae *autoEncrypter) decryptDoc(data []byte) ([]byte, error) {
+	doc, changed, err := ae.decryptFields(data)
+	if err != nil || !changed {
+		return data, err
+	}
+	return bson.Marshal(doc)
+}
+
+func (ae *autoEncrypter) decryptFields(data []byte) (doc bson.D, changed bool, err error) {
+	var raw bson.RawD
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		return nil, false, err
+	}
+	doc = make(bson.D, len(raw))
+	for i, elem := range raw {
+		doc[i] = bson.DocElem{elem.Name, elem.Value}
+		switch elem.Value.Kind {
+		case 0x05:
+			if len(elem.Value.Data) < 5 || elem.Value.Data[4] != BinaryEncrypted {
+				continue
+			}
+			var bin bson.Binary
+			if err := elem.Value.Unmarshal(&bin); err != nil {
+				return nil, false, err
+			}
+			if doc[i].Value, err = ae.client.DecryptRaw(bin); err != nil {
+				return nil, false, fmt.Errorf("cannot decrypt field %q: %v", elem.Name, err)
+			}
+			changed = true
+		case 0x03, 0x04:
+			sub, subChanged, err := ae.decryptFields(elem.Value.Data)
+			if err != nil {
+				return nil, false, err
+			}
+			if subChanged {
+				data, err := bson.Marshal(sub)
+				if err != nil {
+					return nil, false, err
+				}
+				doc[i].Value = bson.Raw{Kind: elem.Value.Kind, Data: data}
+				changed = true
+			}
+		}
+	}
+	return doc, changed, nil
+}
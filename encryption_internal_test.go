@@ -0,0 +1,249 @@
+package mgo
+
+import (
+	"encoding/base64"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+type FLES struct{}
+
+var _ = Suite(&FLES{})
+
+// Test vectors from the client-side encryption specification, produced
+// by the official drivers with the "local" provider.
+var (
+	fleMasterKey   = []byte("2x44+xduTaBBkY16Er5DuADaghvS4vwdkg8tpPp3tz6gV01A1CwbD9itQ2HFDgPWOp8eMaC1Oi766JzXZBdBdbdMurdonJ1d")
+	fleKeyId       = "LOCALAAAAAAAAAAAAAAAAA=="
+	fleKeyMaterial = "Ce9HSz/HKKGkIt4uyy+jDuKGA+rLC2cycykMo6vc8jXxqa1UVDYHWq1r+vZKbnnSRBfB981akzRKZCFpC05CTyFqDhXv6OnMjpG97OZEREGIsHEYiJkBW0jJJvfLLgeLsEpBzsro9FztGGXASxyxFRZFhXvHxyiLOKrdWfs7X1O/iK3pEoHMx6uSNSfUOgbebLfIqW7TO++iQS5g1xovXA=="
+)
+
+var fleVectors = []struct {
+	value      interface{}
+	ciphertext string
+}{{
+	"mongodb",
+	"ASzggCwAAAAAAAAAAAAAAAACW0cZMYWOY3eoqQQkSdBtS9iHC4CSQA27dy6XJGcmTV8EDuhGNnPmbx0EKFTDb0PCSyCjMyuE4nsgmNYgjTaSuw==",
+}, {
+	[]byte{1, 2, 3, 4},
+	"ASzggCwAAAAAAAAAAAAAAAAF1ofBnK9+ERP29P/i14GQ/y3muic6tNKY532zCkzQkJSktYCOeXS8DdY1DdaOP/asZWzPTdgwby6/iZcAxJU+xQ==",
+}}
+
+func decode64(c *C, s string) []byte {
+	data, err := base64.StdEncoding.DecodeString(s)
+	c.Assert(err, IsNil)
+	return data
+}
+
+func (s *FLES) dataKey(c *C) []byte {
+	kms, err := NewLocalKMS(fleMasterKey)
+	c.Assert(err, IsNil)
+	dataKey, err := kms.UnwrapKey(bson.M{"provider": "local"}, decode64(c, fleKeyMaterial))
+	c.Assert(err, IsNil)
+	c.Assert(dataKey, HasLen, 96)
+	return dataKey
+}
+
+func (s *FLES) TestDecryptVectors(c *C) {
+	dataKey := s.dataKey(c)
+	for _, v := range fleVectors {
+		bin := bson.Binary{Kind: BinaryEncrypted, Data: decode64(c, v.ciphertext)}
+		raw, err := fleDecrypt(dataKey, bin)
+		c.Assert(err, IsNil)
+		var value interface{}
+		c.Assert(raw.Unmarshal(&value), IsNil)
+		c.Assert(value, DeepEquals, v.value)
+	}
+}
+
+func (s *FLES) TestEncryptDeterministicVectors(c *C) {
+	dataKey := s.dataKey(c)
+	keyId := decode64(c, fleKeyId)
+	for _, v := range fleVectors {
+		bin, err := fleEncrypt(dataKey, keyId, v.value, AEADDeterministic)
+		c.Assert(err, IsNil)
+		c.Assert(bin.Kind, Equals, byte(BinaryEncrypted))
+		c.Assert(base64.StdEncoding.EncodeToString(bin.Data), Equals, v.ciphertext)
+	}
+}
+
+func (s *FLES) TestEncryptRandom(c *C) {
+	dataKey := s.dataKey(c)
+	keyId := decode64(c, fleKeyId)
+	bin1, err := fleEncrypt(dataKey, keyId, 42, AEADRandom)
+	c.Assert(err, IsNil)
+	bin2, err := fleEncrypt(dataKey, keyId, 42, AEADRandom)
+	c.Assert(err, IsNil)
+	c.Assert(bin1.Data[0], Equals, byte(2))
+	c.Assert(bin1.Data, Not(DeepEquals), bin2.Data)
+
+	raw, err := fleDecrypt(dataKey, bin2)
+	c.Assert(err, IsNil)
+	var value int
+	c.Assert(raw.Unmarshal(&value), IsNil)
+	c.Assert(value, Equals, 42)
+}
+
+func (s *FLES) TestDecryptTampered(c *C) {
+	dataKey := s.dataKey(c)
+	data := decode64(c, fleVectors[0].ciphertext)
+	data[len(data)-40] ^= 1
+	_, err := fleDecrypt(dataKey, bson.Binary{Kind: BinaryEncrypted, Data: data})
+	c.Assert(err, ErrorMatches, "encrypted value failed authentication")
+}
+
+func (s *FLES) TestEncryptRejectedTypes(c *C) {
+	dataKey := s.dataKey(c)
+	keyId := decode64(c, fleKeyId)
+	_, err := fleEncrypt(dataKey, keyId, 1.5, AEADDeterministic)
+	c.Assert(err, ErrorMatches, "cannot encrypt values of BSON type 0x01 deterministically")
+	_, err = fleEncrypt(dataKey, keyId, bson.M{"a": 1}, AEADDeterministic)
+	c.Assert(err, ErrorMatches, "cannot encrypt values of BSON type 0x03 deterministically")
+	_, err = fleEncrypt(dataKey, keyId, nil, AEADRandom)
+	c.Assert(err, ErrorMatches, "cannot encrypt values of BSON type 0x0a")
+	_, err = fleEncrypt(dataKey, keyId, "x", "AES")
+	c.Assert(err, ErrorMatches, `unsupported encryption algorithm: "AES"`)
+}
+
+type fleAddress struct {
+	Street string `bson:"street,encrypt=random,key=addr"`
+	City   string `bson:"city"`
+}
+
+type flePerson struct {
+	Id      int         `bson:"_id"`
+	Name    string      `bson:"name"`
+	SSN     string      `bson:"ssn,encrypt=deterministic,key=ssn"`
+	Address *fleAddress `bson:"address,omitempty"`
+}
+
+func (s *FLES) autoEncrypter(c *C) *autoEncrypter {
+	ce := NewClientEncryption(nil)
+	ce.keys[string(decode64(c, fleKeyId))] = s.dataKey(c)
+	session := &Session{}
+	err := session.SetAutoEncryption(&AutoEncryption{
+		Client: ce,
+		KeyId: func(name string) (bson.Binary, error) {
+			return bson.Binary{Kind: 0x04, Data: decode64(c, fleKeyId)}, nil
+		},
+		Schemas: map[string]interface{}{"mydb.people": flePerson{}},
+	})
+	c.Assert(err, IsNil)
+	return session.encrypter()
+}
+
+func (s *FLES) TestAutoEncryptInsert(c *C) {
+	ae := s.autoEncrypter(c)
+	op, err := ae.encryptOp(&insertOp{"mydb.other", []interface{}{
+		&flePerson{Id: 1, Name: "Ann", SSN: "123", Address: &fleAddress{"Main St", "Springfield"}},
+		bson.M{"ssn": "456"},
+	}, 0})
+	c.Assert(err, IsNil)
+	docs := op.(*insertOp).documents
+
+	data, err := bson.Marshal(docs[0])
+	c.Assert(err, IsNil)
+	var stored struct {
+		Name    string
+		SSN     bson.Binary `bson:"ssn"`
+		Address struct {
+			Street bson.Binary
+			City   string
+		}
+	}
+	c.Assert(bson.Unmarshal(data, &stored), IsNil)
+	c.Assert(stored.Name, Equals, "Ann")
+	c.Assert(stored.SSN.Kind, Equals, byte(BinaryEncrypted))
+	c.Assert(stored.SSN.Data[0], Equals, byte(fleDeterministic))
+	c.Assert(stored.Address.Street.Kind, Equals, byte(BinaryEncrypted))
+	c.Assert(stored.Address.Street.Data[0], Equals, byte(fleRandom))
+	c.Assert(stored.Address.City, Equals, "Springfield")
+
+	// Maps carry no tags, and mydb.other has no schema.
+	c.Assert(docs[1], DeepEquals, bson.M{"ssn": "456"})
+
+	data, err = ae.decryptDoc(data)
+	c.Assert(err, IsNil)
+	var person flePerson
+	c.Assert(bson.Unmarshal(data, &person), IsNil)
+	c.Assert(person, DeepEquals, flePerson{Id: 1, Name: "Ann", SSN: "123", Address: &fleAddress{"Main St", "Springfield"}})
+}
+
+func (s *FLES) TestAutoEncryptQuery(c *C) {
+	ae := s.autoEncrypter(c)
+	encrypted, err := fleEncrypt(s.dataKey(c), decode64(c, fleKeyId), "123", AEADDeterministic)
+	c.Assert(err, IsNil)
+
+	query, err := ae.encryptQuery("mydb.people", bson.M{
+		"name": "Ann",
+		"$or": []bson.M{
+			{"ssn": "123"},
+			{"ssn": bson.M{"$in": []string{"123"}}},
+		},
+	})
+	c.Assert(err, IsNil)
+	var result struct {
+		Name string
+		Or   []struct {
+			SSN bson.Raw `bson:"ssn"`
+		} `bson:"$or"`
+	}
+	data, err := bson.Marshal(query)
+	c.Assert(err, IsNil)
+	c.Assert(bson.Unmarshal(data, &result), IsNil)
+	c.Assert(result.Name, Equals, "Ann")
+	c.Assert(result.Or, HasLen, 2)
+
+	var ssn bson.Binary
+	c.Assert(result.Or[0].SSN.Unmarshal(&ssn), IsNil)
+	c.Assert(ssn, DeepEquals, encrypted)
+	var in struct {
+		In []bson.Binary `bson:"$in"`
+	}
+	c.Assert(result.Or[1].SSN.Unmarshal(&in), IsNil)
+	c.Assert(in.In, DeepEquals, []bson.Binary{encrypted})
+
+	_, err = ae.encryptQuery("mydb.people", bson.M{"ssn": bson.M{"$gt": "1"}})
+	c.Assert(err, ErrorMatches, `cannot use \$gt on field "ssn", as it's encrypted`)
+	_, err = ae.encryptQuery("mydb.people", bson.M{"address.street": "Main St"})
+	c.Assert(err, ErrorMatches, `cannot query field "address.street" encrypted with the random algorithm`)
+
+	// Other collections are left untouched.
+	query, err = ae.encryptQuery("mydb.other", bson.M{"ssn": "123"})
+	c.Assert(err, IsNil)
+	c.Assert(query, DeepEquals, bson.M{"ssn": "123"})
+}
+
+func (s *FLES) TestAutoEncryptUpdate(c *C) {
+	ae := s.autoEncrypter(c)
+	op, err := ae.encryptOp(&updateOp{
+		Collection: "mydb.people",
+		Selector:   bson.M{"ssn": "123"},
+		Update:     bson.M{"$set": bson.M{"ssn": "456", "address.street": "Elm St"}, "$inc": bson.M{"n": 1}},
+	})
+	c.Assert(err, IsNil)
+	update := op.(*updateOp)
+
+	data, err := bson.Marshal(update.Update)
+	c.Assert(err, IsNil)
+	var result struct {
+		Set struct {
+			SSN    bson.Binary `bson:"ssn"`
+			Street bson.Binary `bson:"address.street"`
+		} `bson:"$set"`
+		Inc bson.M `bson:"$inc"`
+	}
+	c.Assert(bson.Unmarshal(data, &result), IsNil)
+	c.Assert(result.Set.SSN.Kind, Equals, byte(BinaryEncrypted))
+	c.Assert(result.Set.Street.Kind, Equals, byte(BinaryEncrypted))
+	c.Assert(result.Inc, DeepEquals, bson.M{"n": 1})
+
+	data, err = bson.Marshal(update.Selector)
+	c.Assert(err, IsNil)
+	var selector struct {
+		SSN bson.Binary `bson:"ssn"`
+	}
+	c.Assert(bson.Unmarshal(data, &selector), IsNil)
+	c.Assert(selector.SSN.Kind, Equals, byte(BinaryEncrypted))
+}
@@ -0,0 +1,171 @@
+package mgo_test
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+var testMasterKey = bytes.Repeat([]byte("0123456789ab"), 8)
+
+func (s *S) TestClientEncryption(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	kms, err := mgo.NewLocalKMS(testMasterKey)
+	c.Assert(err, IsNil)
+	keyVault := session.DB("mydb").C("keyvault")
+	ce := mgo.NewClientEncryption(keyVault, kms)
+
+	keyId, err := ce.CreateDataKey("local", nil, "mykey")
+	c.Assert(err, IsNil)
+	c.Assert(keyId.Kind, Equals, byte(0x04))
+	c.Assert(keyId.Data, HasLen, 16)
+
+	id, err := ce.DataKeyId("mykey")
+	c.Assert(err, IsNil)
+	c.Assert(id, DeepEquals, keyId)
+	_, err = ce.DataKeyId("other")
+	c.Assert(err, Equals, mgo.ErrDataKeyNotFound)
+
+	var key mgo.DataKey
+	err = keyVault.FindId(keyId).One(&key)
+	c.Assert(err, IsNil)
+	c.Assert(key.MasterKey["provider"], Equals, "local")
+	c.Assert(key.KeyAltNames, DeepEquals, []string{"mykey"})
+
+	coll := session.DB("mydb").C("mycoll")
+	for i, name := range []string{"Ann", "Bob", "Ann"} {
+		ename, err := ce.Encrypt(name, keyId, mgo.AEADDeterministic)
+		c.Assert(err, IsNil)
+		c.Assert(ename.Kind, Equals, byte(mgo.BinaryEncrypted))
+		eage, err := ce.Encrypt(30+i, keyId, mgo.AEADRandom)
+		c.Assert(err, IsNil)
+		err = coll.Insert(M{"_id": i, "name": ename, "age": eage})
+		c.Assert(err, IsNil)
+	}
+
+	// Deterministic encryption allows equality queries.
+	query, err := ce.Encrypt("Ann", keyId, mgo.AEADDeterministic)
+	c.Assert(err, IsNil)
+	n, err := coll.Find(M{"name": query}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+
+	var doc struct {
+		Name bson.Binary
+		Age  bson.Binary
+	}
+	err = coll.FindId(1).One(&doc)
+	c.Assert(err, IsNil)
+
+	// A fresh ClientEncryption must unwrap the key from the vault.
+	ce = mgo.NewClientEncryption(keyVault, kms)
+	name, err := ce.Decrypt(doc.Name)
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "Bob")
+	age, err := ce.Decrypt(doc.Age)
+	c.Assert(err, IsNil)
+	c.Assert(age, Equals, 31)
+
+	_, err = ce.Encrypt(1.5, keyId, mgo.AEADDeterministic)
+	c.Assert(err, ErrorMatches, "cannot encrypt values of BSON type 0x01 deterministically")
+	_, err = ce.Encrypt("x", bson.Binary{Kind: 0x04, Data: make([]byte, 16)}, mgo.AEADRandom)
+	c.Assert(err, Equals, mgo.ErrDataKeyNotFound)
+}
+
+func (s *S) TestClientEncryptionWrongMasterKey(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	_, err = mgo.NewLocalKMS([]byte("short"))
+	c.Assert(err, ErrorMatches, "local master key must be 96 bytes long, got 5")
+
+	kms, err := mgo.NewLocalKMS(testMasterKey)
+	c.Assert(err, IsNil)
+	keyVault := session.DB("mydb").C("keyvault")
+	keyId, err := mgo.NewClientEncryption(keyVault, kms).CreateDataKey("local", nil)
+	c.Assert(err, IsNil)
+	_, err = mgo.NewClientEncryption(keyVault, kms).CreateDataKey("aws", nil)
+	c.Assert(err, ErrorMatches, `unknown KMS provider: "aws"`)
+
+	other, err := mgo.NewLocalKMS(bytes.Repeat([]byte("ba9876543210"), 8))
+	c.Assert(err, IsNil)
+	_, err = mgo.NewClientEncryption(keyVault, other).Encrypt("x", keyId, mgo.AEADRandom)
+	c.Assert(err, ErrorMatches, "encrypted value failed authentication")
+}
+
+type encryptedPerson struct {
+	Id   int    `bson:"_id"`
+	Name string `bson:"name"`
+	SSN  string `bson:"ssn,encrypt=deterministic,key=ssnKey"`
+	Note string `bson:"note,omitempty,encrypt=random,key=ssnKey"`
+}
+
+func (s *S) TestAutoEncryption(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	kms, err := mgo.NewLocalKMS(testMasterKey)
+	c.Assert(err, IsNil)
+	ce := mgo.NewClientEncryption(session.DB("mydb").C("keyvault"), kms)
+	_, err = ce.CreateDataKey("local", nil, "ssnKey")
+	c.Assert(err, IsNil)
+
+	auto := session.Copy()
+	defer auto.Close()
+	err = auto.SetAutoEncryption(&mgo.AutoEncryption{
+		Client:  ce,
+		Schemas: map[string]interface{}{"mydb.people": encryptedPerson{}},
+	})
+	c.Assert(err, IsNil)
+
+	people := auto.DB("mydb").C("people")
+	err = people.Insert(
+		&encryptedPerson{1, "Ann", "123", "secret"},
+		&encryptedPerson{2, "Bob", "456", ""},
+	)
+	c.Assert(err, IsNil)
+
+	// The plain session sees the encrypted values only.
+	var raw struct {
+		SSN  bson.Binary `bson:"ssn"`
+		Note bson.Binary `bson:"note"`
+	}
+	err = session.DB("mydb").C("people").FindId(1).One(&raw)
+	c.Assert(err, IsNil)
+	c.Assert(raw.SSN.Kind, Equals, byte(mgo.BinaryEncrypted))
+	c.Assert(raw.Note.Kind, Equals, byte(mgo.BinaryEncrypted))
+
+	var person encryptedPerson
+	err = people.Find(bson.M{"ssn": "456"}).One(&person)
+	c.Assert(err, IsNil)
+	c.Assert(person, Equals, encryptedPerson{2, "Bob", "456", ""})
+
+	err = people.Update(bson.M{"ssn": "456"}, bson.M{"$set": bson.M{"ssn": "789"}})
+	c.Assert(err, IsNil)
+
+	var all []encryptedPerson
+	err = people.Find(bson.M{"ssn": bson.M{"$in": []string{"123", "789"}}}).Sort("_id").All(&all)
+	c.Assert(err, IsNil)
+	c.Assert(all, DeepEquals, []encryptedPerson{{1, "Ann", "123", "secret"}, {2, "Bob", "789", ""}})
+
+	_, err = people.Find(bson.M{"ssn": "123"}).Apply(mgo.Change{
+		Update:    &encryptedPerson{1, "Ann", "321", "other"},
+		ReturnNew: true,
+	}, &person)
+	c.Assert(err, IsNil)
+	c.Assert(person, Equals, encryptedPerson{1, "Ann", "321", "other"})
+
+	n, err := people.Find(bson.M{"ssn": "321"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	err = people.Find(bson.M{"note": "other"}).One(&person)
+	c.Assert(err, ErrorMatches, `cannot query field "note" encrypted with the random algorithm`)
+}
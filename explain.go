@@ -0,0 +1,302 @@
+package mgo
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// Verbosity modes that may be requested via the SetExplainVerbosity
+// methods of Query and Pipe. The mode defines how much of ExplainResult
+// is filled in by the server.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/explain/#explain-command-verbosity
+//
+const (
+	ExplainQueryPlanner      = "queryPlanner"
+	ExplainExecutionStats    = "executionStats"
+	ExplainAllPlansExecution = "allPlansExecution"
+)
+
+// ExplainResult holds the details of how the server would execute a query
+// or pipeline. It may be provided to the Explain methods of Query and Pipe,
+// and is filled in consistently regardless of the server version. Results
+// from servers older than 3.0 are translated into the current layout as far
+// as the information they provide allows.
+//
+// For example:
+//
+//     var result mgo.ExplainResult
+//     err := collection.Find(bson.M{"name": name}).Explain(&result)
+//     if err == nil && result.IsCollectionScan() {
+//         log.Printf("query on name is not using an index")
+//     }
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/explain-results/
+//
+type ExplainResult struct {
+	QueryPlanner   ExplainPlanner
+	ExecutionStats *ExplainStats // Nil in queryPlanner mode
+}
+
+// ExplainPlanner holds the plan selected by the query optimizer
+// and the candidate plans it rejected.
+type ExplainPlanner struct {
+	Namespace      string       `bson:"namespace"`
+	IndexFilterSet bool         `bson:"indexFilterSet"`
+	ParsedQuery    bson.M       `bson:"parsedQuery"`
+	WinningPlan    *PlanStage   `bson:"winningPlan"`
+	RejectedPlans  []*PlanStage `bson:"rejectedPlans"`
+}
+
+// ExplainStats holds the statistics of running the winning plan.
+type ExplainStats struct {
+	ExecutionSuccess    bool       `bson:"executionSuccess"`
+	NReturned           int        `bson:"nReturned"`
+	ExecutionTimeMillis int        `bson:"executionTimeMillis"`
+	TotalKeysExamined   int        `bson:"totalKeysExamined"`
+	TotalDocsExamined   int        `bson:"totalDocsExamined"`
+	ExecutionStages     *PlanStage `bson:"executionStages"`
+}
+
+// PlanStage holds a single stage of a query plan, and the stages that
+// feed it with documents. The execution statistics fields are only set
+// for stages reported within ExplainStats.
+type PlanStage struct {
+	Stage        string       `bson:"stage"`
+	IndexName    string       `bson:"indexName,omitempty"`
+	KeyPattern   bson.D       `bson:"keyPattern,omitempty"`
+	Direction    string       `bson:"direction,omitempty"`
+	IsMultiKey   bool         `bson:"isMultiKey,omitempty"`
+	IndexBounds  bson.M       `bson:"indexBounds,omitempty"`
+	Filter       bson.M       `bson:"filter,omitempty"`
+	InputStage   *PlanStage   `bson:"inputStage,omitempty"`
+	InputStages  []*PlanStage `bson:"inputStages,omitempty"`
+	Shards       []ShardPlan  `bson:"shards,omitempty"`
+	NReturned    int          `bson:"nReturned,omitempty"`
+	Works        int          `bson:"works,omitempty"`
+	Advanced     int          `bson:"advanced,omitempty"`
+	KeysExamined int          `bson:"keysExamined,omitempty"`
+	DocsExamined int          `bson:"docsExamined,omitempty"`
+
+	ExecutionTimeMillisEstimate int `bson:"executionTimeMillisEstimate,omitempty"`
+}
+
+// ShardPlan holds the plan used by an individual shard, as reported
+// when explaining operations that go through a mongos router.
+type ShardPlan struct {
+	ShardName       string       `bson:"shardName"`
+	WinningPlan     *PlanStage   `bson:"winningPlan,omitempty"`
+	RejectedPlans   []*PlanStage `bson:"rejectedPlans,omitempty"`
+	ExecutionStages *PlanStage   `bson:"executionStages,omitempty"`
+}
+
+// SetBSON implements bson.Setter, translating the layout of the
+// server in use into the PlanStage fields.
+func (stage *PlanStage) SetBSON(raw bson.Raw) error {
+	type planStage PlanStage
+	var doc struct {
+		planStage `bson:",inline"`
+
+		// Servers using the slot-based engine (5.0+) wrap the
+		// classic plan tree.
+		QueryPlan *planStage `bson:"queryPlan"`
+	}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+	if doc.Stage == "" && doc.QueryPlan != nil {
+		*stage = PlanStage(*doc.QueryPlan)
+	} else {
+		*stage = PlanStage(doc.planStage)
+	}
+	return nil
+}
+
+type legacyExplain struct {
+	Cursor          string
+	N               int
+	NScanned        int `bson:"nscanned"`
+	NScannedObjects int `bson:"nscannedObjects"`
+	Millis          int
+	IndexBounds     bson.M `bson:"indexBounds"`
+	AllPlans        []struct {
+		Cursor      string
+		IndexBounds bson.M `bson:"indexBounds"`
+	} `bson:"allPlans"`
+}
+
+// SetBSON implements bson.Setter, translating the layout of the
+// server in use into the ExplainResult fields.
+func (result *ExplainResult) SetBSON(raw bson.Raw) error {
+	var doc struct {
+		QueryPlanner   *ExplainPlanner `bson:"queryPlanner"`
+		ExecutionStats *ExplainStats   `bson:"executionStats"`
+
+		// Aggregations report the plan of the initial
+		// query within the first pipeline stage.
+		Stages []struct {
+			Cursor *struct {
+				QueryPlanner   *ExplainPlanner `bson:"queryPlanner"`
+				ExecutionStats *ExplainStats   `bson:"executionStats"`
+			} `bson:"$cursor"`
+		} `bson:"stages"`
+	}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+	if doc.QueryPlanner == nil && len(doc.Stages) > 0 && doc.Stages[0].Cursor != nil {
+		doc.QueryPlanner = doc.Stages[0].Cursor.QueryPlanner
+		doc.ExecutionStats = doc.Stages[0].Cursor.ExecutionStats
+	}
+	if doc.QueryPlanner != nil {
+		result.QueryPlanner = *doc.QueryPlanner
+		result.ExecutionStats = doc.ExecutionStats
+		return nil
+	}
+
+	// Servers prior to 3.0 report a single flat document.
+	var legacy legacyExplain
+	if err := raw.Unmarshal(&legacy); err != nil {
+		return err
+	}
+	winning := legacyPlanStage(legacy.Cursor, legacy.IndexBounds)
+	result.QueryPlanner = ExplainPlanner{WinningPlan: winning}
+	for _, plan := range legacy.AllPlans {
+		if plan.Cursor != legacy.Cursor {
+			rejected := legacyPlanStage(plan.Cursor, plan.IndexBounds)
+			result.QueryPlanner.RejectedPlans = append(result.QueryPlanner.RejectedPlans, rejected)
+		}
+	}
+	result.ExecutionStats = &ExplainStats{
+		ExecutionSuccess:    true,
+		NReturned:           legacy.N,
+		ExecutionTimeMillis: legacy.Millis,
+		TotalKeysExamined:   legacy.NScanned,
+		TotalDocsExamined:   legacy.NScannedObjects,
+		ExecutionStages:     winning,
+	}
+	return nil
+}
+
+// legacyPlanStage returns the stage equivalent to the cursor description
+// reported by servers prior to 3.0, such as "BtreeCursor name_1 reverse".
+func legacyPlanStage(cursor string, bounds bson.M) *PlanStage {
+	fields := strings.Fields(cursor)
+	if len(fields) == 0 || fields[0] == "BasicCursor" {
+		return &PlanStage{Stage: "COLLSCAN"}
+	}
+	stage := &PlanStage{Stage: "IXSCAN", IndexBounds: bounds}
+	if len(fields) > 1 {
+		stage.IndexName = fields[1]
+	}
+	if fields[0] == "BtreeCursor" && len(fields) > 2 && fields[2] == "reverse" {
+		stage.Direction = "backward"
+	}
+	return stage
+}
+
+// Children returns the stages that feed this stage, including the
+// plans of individual shards.
+func (stage *PlanStage) Children() []*PlanStage {
+	var children []*PlanStage
+	if stage.InputStage != nil {
+		children = append(children, stage.InputStage)
+	}
+	children = append(children, stage.InputStages...)
+	for _, shard := range stage.Shards {
+		if shard.WinningPlan != nil {
+			children = append(children, shard.WinningPlan)
+		}
+		if shard.ExecutionStages != nil {
+			children = append(children, shard.ExecutionStages)
+		}
+	}
+	return children
+}
+
+// Walk calls f for the stage and all the stages that feed it, depth
+// first, stopping and returning true if f returns true.
+func (stage *PlanStage) Walk(f func(stage *PlanStage) bool) bool {
+	if stage == nil {
+		return false
+	}
+	if f(stage) {
+		return true
+	}
+	for _, child := range stage.Children() {
+		if child.Walk(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesIndex returns whether the winning plan reads from the index with
+// the provided name.
+func (result *ExplainResult) UsesIndex(name string) bool {
+	for _, used := range result.IndexesUsed() {
+		if used == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexesUsed returns the names of all indexes read from by the
+// winning plan.
+func (result *ExplainResult) IndexesUsed() []string {
+	var names []string
+	seen := make(map[string]bool)
+	result.QueryPlanner.WinningPlan.Walk(func(stage *PlanStage) bool {
+		name := stage.IndexName
+		if name == "" && stage.Stage == "IDHACK" {
+			name = "_id_"
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return false
+	})
+	return names
+}
+
+// IsCollectionScan returns whether the winning plan scans the
+// whole collection rather than using an index.
+func (result *ExplainResult) IsCollectionScan() bool {
+	return result.QueryPlanner.WinningPlan.Walk(func(stage *PlanStage) bool {
+		return stage.Stage == "COLLSCAN"
+	})
+}
+
+// DocsExamined returns the number of documents examined while executing
+// the winning plan, or zero if execution statistics are not available.
+func (result *ExplainResult) DocsExamined() int {
+	if result.ExecutionStats == nil {
+		return 0
+	}
+	return result.ExecutionStats.TotalDocsExamined
+}
+
+// KeysExamined returns the number of index keys examined while executing
+// the winning plan, or zero if execution statistics are not available.
+func (result *ExplainResult) KeysExamined() int {
+	if result.ExecutionStats == nil {
+		return 0
+	}
+	return result.ExecutionStats.TotalKeysExamined
+}
+
+// DocsReturned returns the number of documents returned by the winning
+// plan, or zero if execution statistics are not available.
+func (result *ExplainResult) DocsReturned() int {
+	if result.ExecutionStats == nil {
+		return 0
+	}
+	return result.ExecutionStats.NReturned
+}
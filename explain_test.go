@@ -0,0 +1,252 @@
+package mgo_test
+
+import (
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+type ExplainS struct{}
+
+var _ = Suite(&ExplainS{})
+
+var explainTests = []struct {
+	summary  string
+	doc      bson.M
+	indexes  []string
+	collscan bool
+	examined int
+	returned int
+}{{
+	"3.0+ index scan",
+	bson.M{
+		"queryPlanner": bson.M{
+			"namespace": "mydb.mycoll",
+			"winningPlan": bson.M{
+				"stage": "FETCH",
+				"inputStage": bson.M{
+					"stage":     "IXSCAN",
+					"indexName": "a_1",
+				},
+			},
+			"rejectedPlans": []bson.M{{"stage": "COLLSCAN"}},
+		},
+		"executionStats": bson.M{
+			"nReturned":         2,
+			"totalKeysExamined": 2,
+			"totalDocsExamined": 2,
+		},
+	},
+	[]string{"a_1"}, false, 2, 2,
+}, {
+	"3.0+ collection scan without execution stats",
+	bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{"stage": "COLLSCAN"},
+		},
+	},
+	nil, true, 0, 0,
+}, {
+	"3.0+ index intersection",
+	bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{
+				"stage": "FETCH",
+				"inputStage": bson.M{
+					"stage": "AND_SORTED",
+					"inputStages": []bson.M{
+						{"stage": "IXSCAN", "indexName": "a_1"},
+						{"stage": "IXSCAN", "indexName": "b_1"},
+					},
+				},
+			},
+		},
+	},
+	[]string{"a_1", "b_1"}, false, 0, 0,
+}, {
+	"_id lookup",
+	bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{"stage": "IDHACK"},
+		},
+	},
+	[]string{"_id_"}, false, 0, 0,
+}, {
+	"5.0+ slot-based engine",
+	bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{
+				"queryPlan": bson.M{
+					"stage": "FETCH",
+					"inputStage": bson.M{
+						"stage":     "IXSCAN",
+						"indexName": "a_1",
+					},
+				},
+				"slotBasedPlan": bson.M{"stages": "..."},
+			},
+		},
+		"executionStats": bson.M{"nReturned": 1, "totalDocsExamined": 3},
+	},
+	[]string{"a_1"}, false, 3, 1,
+}, {
+	"sharded",
+	bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{
+				"stage": "SHARD_MERGE",
+				"shards": []bson.M{{
+					"shardName":   "s1",
+					"winningPlan": bson.M{"stage": "COLLSCAN"},
+				}, {
+					"shardName":   "s2",
+					"winningPlan": bson.M{"stage": "IXSCAN", "indexName": "a_1"},
+				}},
+			},
+		},
+	},
+	[]string{"a_1"}, true, 0, 0,
+}, {
+	"aggregation",
+	bson.M{
+		"stages": []bson.M{{
+			"$cursor": bson.M{
+				"queryPlanner": bson.M{
+					"winningPlan": bson.M{"stage": "IXSCAN", "indexName": "a_1"},
+				},
+			},
+		}, {
+			"$project": bson.M{"a": true},
+		}},
+	},
+	[]string{"a_1"}, false, 0, 0,
+}, {
+	"2.x collection scan",
+	bson.M{
+		"cursor":          "BasicCursor",
+		"n":               2,
+		"nscanned":        3,
+		"nscannedObjects": 3,
+	},
+	nil, true, 3, 2,
+}, {
+	"2.x index scan",
+	bson.M{
+		"cursor":          "BtreeCursor a_1 reverse",
+		"n":               1,
+		"nscanned":        1,
+		"nscannedObjects": 1,
+		"allPlans": []bson.M{
+			{"cursor": "BtreeCursor a_1 reverse"},
+			{"cursor": "BasicCursor"},
+		},
+	},
+	[]string{"a_1"}, false, 1, 1,
+}}
+
+func (s *ExplainS) TestExplainResult(c *C) {
+	for _, test := range explainTests {
+		c.Logf("Testing: %s", test.summary)
+		data, err := bson.Marshal(test.doc)
+		c.Assert(err, IsNil)
+		var result mgo.ExplainResult
+		err = bson.Unmarshal(data, &result)
+		c.Assert(err, IsNil)
+		c.Assert(result.IndexesUsed(), DeepEquals, test.indexes)
+		for _, name := range test.indexes {
+			c.Assert(result.UsesIndex(name), Equals, true)
+		}
+		c.Assert(result.UsesIndex("none_1"), Equals, false)
+		c.Assert(result.IsCollectionScan(), Equals, test.collscan)
+		c.Assert(result.DocsExamined(), Equals, test.examined)
+		c.Assert(result.DocsReturned(), Equals, test.returned)
+	}
+}
+
+func (s *ExplainS) TestExplainResultLegacyRejectedPlans(c *C) {
+	data, err := bson.Marshal(explainTests[len(explainTests)-1].doc)
+	c.Assert(err, IsNil)
+	var result mgo.ExplainResult
+	err = bson.Unmarshal(data, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.QueryPlanner.WinningPlan.Direction, Equals, "backward")
+	c.Assert(result.QueryPlanner.RejectedPlans, HasLen, 1)
+	c.Assert(result.QueryPlanner.RejectedPlans[0].Stage, Equals, "COLLSCAN")
+}
+
+func (s *S) TestQueryExplainResult(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.EnsureIndexKey("a")
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 5; i++ {
+		err := coll.Insert(M{"a": i, "b": i})
+		c.Assert(err, IsNil)
+	}
+
+	var result mgo.ExplainResult
+	err = coll.Find(M{"a": M{"$gte": 3}}).Explain(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.UsesIndex("a_1"), Equals, true)
+	c.Assert(result.IsCollectionScan(), Equals, false)
+	c.Assert(result.DocsExamined(), Equals, 2)
+	c.Assert(result.DocsReturned(), Equals, 2)
+
+	result = mgo.ExplainResult{}
+	err = coll.Find(M{"b": 3}).Explain(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.UsesIndex("a_1"), Equals, false)
+	c.Assert(result.IsCollectionScan(), Equals, true)
+	c.Assert(result.DocsExamined(), Equals, 5)
+}
+
+func (s *S) TestQueryExplainVerbosity(c *C) {
+	if !s.versionAtLeast(3, 2) {
+		c.Skip("explain verbosity requires 3.2+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"a": 1})
+	c.Assert(err, IsNil)
+
+	var result mgo.ExplainResult
+	err = coll.Find(M{"a": 1}).SetExplainVerbosity(mgo.ExplainQueryPlanner).Explain(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.IsCollectionScan(), Equals, true)
+	c.Assert(result.ExecutionStats, IsNil)
+
+	result = mgo.ExplainResult{}
+	err = coll.Find(M{"a": 1}).SetExplainVerbosity(mgo.ExplainExecutionStats).Explain(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.ExecutionStats, NotNil)
+	c.Assert(result.DocsReturned(), Equals, 1)
+}
+
+func (s *S) TestPipeExplainResult(c *C) {
+	if !s.versionAtLeast(3, 6) {
+		c.Skip("explain verbosity on pipelines requires 3.6+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.EnsureIndexKey("a")
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"a": 1}, M{"a": 2})
+	c.Assert(err, IsNil)
+
+	pipe := coll.Pipe([]M{{"$match": M{"a": 2}}, {"$project": M{"a": 1}}})
+	var result mgo.ExplainResult
+	err = pipe.SetExplainVerbosity(mgo.ExplainExecutionStats).Explain(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.UsesIndex("a_1"), Equals, true)
+	c.Assert(result.DocsExamined(), Equals, 1)
+}
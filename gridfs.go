@@ -28,11 +28,15 @@ package mgo
 
 import (
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -42,6 +46,62 @@ import (
 type GridFS struct {
 	Files  *Collection
 	Chunks *Collection
+
+	hash   GridFSHash
+	codec  GridFSCodec
+	codecs map[string]GridFSCodec
+	dedup  bool
+}
+
+// GridFSHash identifies the algorithm used to compute the digest of the
+// content of GridFS files. The digest is stored in the file document,
+// in the field named after the algorithm. See GridFS.SetHash.
+type GridFSHash string
+
+const (
+	GridFSHashNone   GridFSHash = "none"
+	GridFSHashMD5    GridFSHash = "md5"
+	GridFSHashSHA1   GridFSHash = "sha1"
+	GridFSHashSHA256 GridFSHash = "sha256"
+)
+
+// gfsHashes holds the algorithms a file digest may be stored with,
+// in order of preference.
+var gfsHashes = []GridFSHash{GridFSHashSHA256, GridFSHashSHA1, GridFSHashMD5}
+
+// check returns an error if h isn't a known algorithm.
+func (h GridFSHash) check() error {
+	switch h {
+	case GridFSHashNone, GridFSHashMD5, GridFSHashSHA1, GridFSHashSHA256, "":
+		return nil
+	}
+	return fmt.Errorf("unknown GridFS hash algorithm: %s", string(h))
+}
+
+// new returns a new hash computing the digest, or nil
+// if h is GridFSHashNone. The algorithm must be known.
+func (h GridFSHash) new() hash.Hash {
+	switch h {
+	case GridFSHashMD5, "":
+		return md5.New()
+	case GridFSHashSHA1:
+		return sha1.New()
+	case GridFSHashSHA256:
+		return sha256.New()
+	case GridFSHashNone:
+		return nil
+	}
+	panic("unknown GridFS hash algorithm: " + string(h))
+}
+
+func (h GridFSHash) name() string {
+	switch h {
+	case GridFSHashSHA1:
+		return "SHA-1"
+	case GridFSHashSHA256:
+		return "SHA-256"
+	}
+	return "MD5"
 }
 
 type gfsFileMode int
@@ -62,12 +122,31 @@ type GridFile struct {
 	chunk  int
 	offset int64
 
-	wpending int
-	wbuf     []byte
-	wsum     hash.Hash
+	wpending      int
+	wpendingBytes int
+	wbuf          []byte
+	wsum          hash.Hash
+	whash         GridFSHash
+	wcodec        GridFSCodec
+	wdedup        bool
+	wblobs        []gfsBlobRef
+	wbatch        []interface{}
+	wbatchBytes   int
+	wsettings     GridFSWriteSettings
+
+	rbuf      []byte
+	rcache    []*gfsCachedChunk
+	readAhead int
 
-	rbuf   []byte
-	rcache *gfsCachedChunk
+	// session is closed with the file, when set.
+	session *Session
+
+	// resumable files keep their chunks when closed with an error,
+	// unless aborted, and are recorded as pending uploads until
+	// complete. See GridFS.CreateResumable.
+	resumable   bool
+	aborted     bool
+	uploadSaved bool
 
 	doc gfsFile
 }
@@ -77,10 +156,13 @@ type gfsFile struct {
 	ChunkSize   int         "chunkSize"
 	UploadDate  time.Time   "uploadDate"
 	Length      int64       ",minsize"
-	MD5         string
-	Filename    string    ",omitempty"
-	ContentType string    "contentType,omitempty"
-	Metadata    *bson.Raw ",omitempty"
+	MD5         string      ",omitempty"
+	SHA1        string      "sha1,omitempty"
+	SHA256      string      "sha256,omitempty"
+	Filename    string      ",omitempty"
+	ContentType string      "contentType,omitempty"
+	Codec       string      "codec,omitempty"
+	Metadata    *bson.Raw   ",omitempty"
 }
 
 type gfsChunk struct {
@@ -88,6 +170,30 @@ type gfsChunk struct {
 	FilesId interface{} "files_id"
 	N       int
 	Data    []byte
+	Ref     string ",omitempty"
+}
+
+// GridFSWriteSettings holds the settings for the insertion of the chunks
+// of files being written, which happens in background while more data is
+// written. See GridFile.SetWriteSettings.
+type GridFSWriteSettings struct {
+	// MaxPendingBytes is how much chunk data may be pending insertion
+	// before writes block. Defaults to 1MB.
+	MaxPendingBytes int
+
+	// MaxConcurrency is how many chunk insertions may be in progress
+	// at once. Defaults to no limit other than MaxPendingBytes.
+	MaxConcurrency int
+
+	// MaxRetries is how many times the insertion of chunks is retried
+	// on a new connection after failing, before the file errors out.
+	// Retrying is safe, as chunks that turn out to be inserted already
+	// are detected by their id. Defaults to no retries.
+	MaxRetries int
+
+	// BatchChunks is how many chunks are sent in a single insert
+	// message. Defaults to 1.
+	BatchChunks int
 }
 
 type gfsCachedChunk struct {
@@ -98,11 +204,56 @@ type gfsCachedChunk struct {
 }
 
 func newGridFS(db *Database, prefix string) *GridFS {
-	return &GridFS{db.C(prefix + ".files"), db.C(prefix + ".chunks")}
+	return &GridFS{Files: db.C(prefix + ".files"), Chunks: db.C(prefix + ".chunks")}
+}
+
+// With returns a copy of gfs that uses session s, with the same settings.
+func (gfs *GridFS) With(s *Session) *GridFS {
+	newgfs := *gfs
+	newgfs.Files = gfs.Files.With(s)
+	newgfs.Chunks = gfs.Chunks.With(s)
+	return &newgfs
+}
+
+// SetHash sets the algorithm used to compute the digest of the content
+// of the files created via gfs from now on. The digest is computed with
+// MD5 by default, and is not computed at all with GridFSHashNone. It
+// may be retrieved via GridFile.Digest once the file is closed, or via
+// GridFile.MD5 for files hashed with MD5. An error is returned, and the
+// algorithm is left unchanged, if h isn't one of the known algorithms.
+//
+// For example, the following snippet prevents the use of MD5, which is
+// disallowed in FIPS environments:
+//
+//     gfs := db.GridFS("fs")
+//     err := gfs.SetHash(mgo.GridFSHashSHA256)
+//     check(err)
+//
+func (gfs *GridFS) SetHash(h GridFSHash) error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	gfs.hash = h
+	return nil
+}
+
+// newWriter prepares file for writing content hashed with h,
+// or with MD5 if h is empty.
+func (file *GridFile) newWriter(h GridFSHash) error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	if h == "" {
+		h = GridFSHashMD5
+	}
+	file.mode = gfsWriting
+	file.whash = h
+	file.wsum = h.new()
+	return nil
 }
 
 func (gfs *GridFS) newFile() *GridFile {
-	file := &GridFile{gfs: gfs}
+	file := &GridFile{gfs: gfs, readAhead: 1, wsettings: normalizeWriteSettings(GridFSWriteSettings{})}
 	file.c.L = &file.m
 	//runtime.SetFinalizer(file, finalizeFile)
 	return file
@@ -151,10 +302,19 @@ func finalizeFile(file *GridFile) {
 //     check(err)
 //
 func (gfs *GridFS) Create(name string) (file *GridFile, err error) {
+	if err = checkDedup(gfs.dedup, gfs.codec); err != nil {
+		return nil, err
+	}
 	file = gfs.newFile()
-	file.mode = gfsWriting
-	file.wsum = md5.New()
+	if err = file.newWriter(gfs.hash); err != nil {
+		return nil, err
+	}
 	file.doc = gfsFile{Id: bson.NewObjectId(), ChunkSize: 255 * 1024, Filename: name}
+	if gfs.codec != nil {
+		file.wcodec = gfs.codec
+		file.doc.Codec = gfs.codec.Name()
+	}
+	file.wdedup = gfs.dedup
 	return
 }
 
@@ -319,7 +479,7 @@ func (gfs *GridFS) RemoveId(id interface{}) error {
 	if err != nil {
 		return err
 	}
-	_, err = gfs.Chunks.RemoveAll(bson.D{{"files_id", id}})
+	_, err = gfs.removeChunks(bson.D{{"files_id", id}})
 	return err
 }
 
@@ -342,6 +502,406 @@ func (gfs *GridFS) Remove(name string) (err error) {
 	return err
 }
 
+// GridFSReport holds the outcome of verifying a file with GridFS.Verify.
+type GridFSReport struct {
+	// Id identifies the file verified.
+	Id interface{}
+
+	// Chunks holds the number of chunks found for the file.
+	Chunks int
+
+	// MissingChunks holds the numbers of the chunks the file
+	// document accounts for that weren't found.
+	MissingChunks []int
+
+	// Problems describes each inconsistency found, including
+	// the missing chunks. It's empty if the file is intact.
+	Problems []string
+}
+
+// OK returns whether no inconsistencies were found in the file.
+func (r *GridFSReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *GridFSReport) problemf(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// Verify checks the file with the provided id against its chunks,
+// which must all be present and sized consistently with the length and
+// chunk size of the file, and hash to the digests held by the file
+// document, if any. The inconsistencies found are described
+// in the returned report. If the file isn't found, err will be set to
+// ErrNotFound.
+//
+// For example:
+//
+//     report, err := gfs.Verify(id)
+//     check(err)
+//     for _, problem := range report.Problems {
+//         log.Printf("file %v: %s", id, problem)
+//     }
+//
+func (gfs *GridFS) Verify(id interface{}) (report *GridFSReport, err error) {
+	var doc gfsFile
+	if err = gfs.Files.FindId(id).One(&doc); err != nil {
+		return nil, err
+	}
+	report = &GridFSReport{Id: id}
+	if doc.ChunkSize <= 0 {
+		report.problemf("invalid chunk size %d", doc.ChunkSize)
+		return report, nil
+	}
+	if _, err = gfs.codecFor(doc.Codec); err != nil {
+		return nil, err
+	}
+	file := gfs.newFile()
+	file.doc = doc
+	expected := int((doc.Length + int64(doc.ChunkSize) - 1) / int64(doc.ChunkSize))
+	sums := make(map[GridFSHash]hash.Hash)
+	for _, h := range gfsHashes {
+		if *doc.digest(h) != "" {
+			sums[h] = h.new()
+		}
+	}
+
+	iter := gfs.Chunks.Find(bson.D{{"files_id", id}}).Sort("n").Iter()
+	next := 0
+	var chunk gfsChunk
+	for iter.Next(&chunk) {
+		report.Chunks++
+		switch {
+		case chunk.N < next:
+			report.problemf("chunk %d is duplicated", chunk.N)
+		case chunk.N >= expected:
+			report.problemf("chunk %d is beyond the file length of %d bytes", chunk.N, doc.Length)
+		default:
+			for ; next < chunk.N; next++ {
+				report.MissingChunks = append(report.MissingChunks, next)
+				report.problemf("chunk %d is missing", next)
+			}
+			next++
+			data, err := file.chunkData(gfs.Chunks, &chunk)
+			if err != nil {
+				report.problemf("chunk %d cannot be read: %v", chunk.N, err)
+				break
+			}
+			size := int64(doc.ChunkSize)
+			if chunk.N == expected-1 {
+				size = doc.Length - int64(chunk.N)*size
+			}
+			if int64(len(data)) != size {
+				report.problemf("chunk %d has %d bytes rather than %d", chunk.N, len(data), size)
+			}
+			for _, sum := range sums {
+				sum.Write(data)
+			}
+		}
+		chunk = gfsChunk{}
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+	for ; next < expected; next++ {
+		report.MissingChunks = append(report.MissingChunks, next)
+		report.problemf("chunk %d is missing", next)
+	}
+	if !report.OK() {
+		// Digests of incomplete content are bound to mismatch.
+		return report, nil
+	}
+	for i := len(gfsHashes) - 1; i >= 0; i-- {
+		h := gfsHashes[i]
+		if sums[h] == nil {
+			continue
+		}
+		if sum, want := hex.EncodeToString(sums[h].Sum(nil)), *doc.digest(h); sum != want {
+			report.problemf("content has %s %s rather than %s", h.name(), sum, want)
+		}
+	}
+	return report, nil
+}
+
+// GridFSGarbage holds the outcome of a GridFS.CollectGarbage run.
+type GridFSGarbage struct {
+	// Files holds the number of distinct missing files
+	// that chunks were removed for.
+	Files int
+
+	// Chunks holds the number of chunks removed.
+	Chunks int
+
+	// Blobs holds the number of deduplicated chunk contents removed
+	// because no chunk references them. See SetDedup.
+	Blobs int
+}
+
+// gcBatchSize is how many distinct file ids CollectGarbage
+// looks up at once.
+const gcBatchSize = 100
+
+// CollectGarbage removes the chunks stored more than olderThan ago that
+// belong to no file, and to no pending upload (see CreateResumable).
+// Such chunks are left behind when a process writing a file goes away
+// before closing it, or when removing a file fails halfway.
+//
+// Files only become visible once all of their chunks are written, so
+// olderThan must be comfortably longer than the time taken to write the
+// largest files, or the chunks of files being written will be removed
+// as well. Chunks with ids that aren't ObjectIds, which don't carry the
+// time they were stored at, are never removed.
+//
+// Deduplicated content last referenced more than olderThan ago that no
+// chunk references is removed as well, such as content stored by writes
+// that failed before inserting the chunks referencing it.
+//
+// The chunks are looked up and removed in batches, so the collection
+// may be large and in use while this runs.
+func (gfs *GridFS) CollectGarbage(olderThan time.Duration) (garbage *GridFSGarbage, err error) {
+	garbage = &GridFSGarbage{}
+	limit := bson.NewObjectIdWithTime(time.Now().Add(-olderThan))
+	old := bson.M{"$lt": limit}
+	iter := gfs.Chunks.Find(bson.M{"_id": old}).Select(bson.M{"files_id": 1}).Sort("files_id").Iter()
+
+	var ids []interface{}
+	var chunk gfsChunk
+	var last string
+	for {
+		ok := iter.Next(&chunk)
+		if ok {
+			key := gcKey(chunk.FilesId)
+			if len(ids) > 0 && key == last {
+				continue
+			}
+			last = key
+			ids = append(ids, chunk.FilesId)
+		}
+		if len(ids) == gcBatchSize || !ok && len(ids) > 0 {
+			if err = gfs.collectBatch(ids, old, garbage); err != nil {
+				iter.Close()
+				return garbage, err
+			}
+			ids = ids[:0]
+		}
+		if !ok {
+			break
+		}
+		chunk = gfsChunk{}
+	}
+	if err = iter.Close(); err != nil {
+		return garbage, err
+	}
+	garbage.Blobs, err = gfs.collectBlobs(limit)
+	return garbage, err
+}
+
+// gcKey returns a key identifying the provided file id, which
+// may not be comparable as a document or an array.
+func gcKey(id interface{}) string {
+	data, err := bson.Marshal(bson.D{{"id", id}})
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// collectBatch removes the chunks matching old of those of the
+// provided file ids that belong to no file or pending upload.
+func (gfs *GridFS) collectBatch(ids []interface{}, old bson.M, garbage *GridFSGarbage) error {
+	live := make(map[string]bool)
+	for _, c := range []*Collection{gfs.Files, gfs.uploads()} {
+		var doc gfsDocId
+		iter := c.Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"_id": 1}).Iter()
+		for iter.Next(&doc) {
+			live[gcKey(doc.Id)] = true
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+	var orphans []interface{}
+	for _, id := range ids {
+		if !live[gcKey(id)] {
+			orphans = append(orphans, id)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	removed, err := gfs.removeChunks(bson.D{{"files_id", bson.M{"$in": orphans}}, {"_id", old}})
+	if err != nil {
+		return err
+	}
+	debugf("GridFS %s: removed %d orphaned chunks of %d files", gfs.Chunks.FullName, removed, len(orphans))
+	garbage.Files += len(orphans)
+	garbage.Chunks += removed
+	return nil
+}
+
+// PendingUpload holds the details of a resumable upload that
+// wasn't completed yet. See GridFS.PendingUploads.
+type PendingUpload struct {
+	Id          interface{} "_id"
+	ChunkSize   int         "chunkSize"
+	Filename    string      ",omitempty"
+	ContentType string      "contentType,omitempty"
+	Metadata    *bson.Raw   ",omitempty"
+	StartDate   time.Time   "startDate"
+	Hash        GridFSHash  "hash,omitempty"
+	Codec       string      "codec,omitempty"
+	Dedup       bool        "dedup,omitempty"
+}
+
+// uploads returns the collection recording the pending uploads.
+func (gfs *GridFS) uploads() *Collection {
+	return gfs.Files.Database.C(strings.TrimSuffix(gfs.Files.Name, ".files") + ".uploads")
+}
+
+// CreateResumable creates a new file with the provided id in the GridFS,
+// just like Create, but which may be resumed via ResumeUpload if writing
+// it fails halfway, or if the writer goes away before closing it. The id
+// should be one the writer is able to recover, such as one derived from
+// the content being uploaded.
+//
+// Once the first chunk of the file is stored, the upload is recorded as
+// pending, and stays so until the file is closed successfully or Abort is
+// used. Pending uploads are listed by PendingUploads.
+//
+// The name, content type, chunk size and metadata of the file must be set
+// before it's written to, to be preserved when the upload is resumed.
+func (gfs *GridFS) CreateResumable(id interface{}) (file *GridFile, err error) {
+	file, err = gfs.Create("")
+	if err == nil {
+		file.doc.Id = id
+		file.resumable = true
+	}
+	return
+}
+
+// ResumeUpload returns the file with the provided id, created via
+// CreateResumable and not yet completed, for writing. The stored chunks
+// are read back in order to continue the computation of the checksum,
+// and writing continues after the last chunk stored in full for which
+// all prior chunks are also stored. Size reports the amount of data
+// stored, which is where the writer must continue from. If no pending
+// upload with the id is found, err will be set to ErrNotFound.
+//
+// For example:
+//
+//     file, err := gfs.ResumeUpload(id)
+//     check(err)
+//     _, err = src.Seek(file.Size(), os.SEEK_SET)
+//     check(err)
+//     _, err = io.Copy(file, src)
+//     check(err)
+//     err = file.Close()
+//     check(err)
+//
+func (gfs *GridFS) ResumeUpload(id interface{}) (file *GridFile, err error) {
+	var upload PendingUpload
+	if err = gfs.uploads().FindId(id).One(&upload); err != nil {
+		return nil, err
+	}
+	file = gfs.newFile()
+	if err = file.newWriter(upload.Hash); err != nil {
+		return nil, err
+	}
+	file.resumable = true
+	file.uploadSaved = true
+	file.doc = gfsFile{
+		Id:          id,
+		ChunkSize:   upload.ChunkSize,
+		Filename:    upload.Filename,
+		ContentType: upload.ContentType,
+		Codec:       upload.Codec,
+		Metadata:    upload.Metadata,
+	}
+	if file.wcodec, err = gfs.codecFor(upload.Codec); err != nil {
+		return nil, err
+	}
+	if err = checkDedup(upload.Dedup, file.wcodec); err != nil {
+		return nil, err
+	}
+	file.wdedup = upload.Dedup
+
+	iter := gfs.Chunks.Find(bson.D{{"files_id", id}}).Sort("n").Iter()
+	var chunk gfsChunk
+	for iter.Next(&chunk) && chunk.N == file.chunk {
+		data, err := file.chunkData(gfs.Chunks, &chunk)
+		if err != nil || len(data) != upload.ChunkSize {
+			break
+		}
+		if file.wsum != nil {
+			file.wsum.Write(data)
+		}
+		file.chunk++
+		chunk = gfsChunk{}
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+	file.doc.Length = int64(file.chunk) * int64(upload.ChunkSize)
+	debugf("GridFile %p: resuming upload after %d chunks", file, file.chunk)
+
+	// Chunks following a missing or incomplete one are written again.
+	_, err = gfs.removeChunks(bson.D{{"files_id", id}, {"n", bson.D{{"$gte", file.chunk}}}})
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// PendingUploads returns a query on the resumable uploads that weren't
+// completed yet, which may be unmarshalled into PendingUpload values.
+//
+// For example, the following snippet removes the uploads started
+// more than a day ago:
+//
+//     var upload mgo.PendingUpload
+//     iter := gfs.PendingUploads(bson.M{"startDate": bson.M{"$lt": time.Now().Add(-24 * time.Hour)}}).Iter()
+//     for iter.Next(&upload) {
+//         err := gfs.RemoveUpload(upload.Id)
+//         check(err)
+//     }
+//     err := iter.Close()
+//     check(err)
+//
+func (gfs *GridFS) PendingUploads(query interface{}) *Query {
+	return gfs.uploads().Find(query)
+}
+
+// RemoveUpload deletes the pending upload with the provided id and its
+// chunks. If the upload isn't found, err will be set to ErrNotFound.
+func (gfs *GridFS) RemoveUpload(id interface{}) error {
+	err := gfs.uploads().RemoveId(id)
+	if err != nil {
+		return err
+	}
+	_, err = gfs.removeChunks(bson.D{{"files_id", id}})
+	return err
+}
+
+// saveUpload records the file as a pending upload.
+func (file *GridFile) saveUpload() error {
+	upload := PendingUpload{
+		Id:          file.doc.Id,
+		ChunkSize:   file.doc.ChunkSize,
+		Filename:    file.doc.Filename,
+		ContentType: file.doc.ContentType,
+		Metadata:    file.doc.Metadata,
+		StartDate:   bson.Now(),
+		Hash:        file.whash,
+		Codec:       file.doc.Codec,
+		Dedup:       file.wdedup,
+	}
+	if _, err := file.gfs.uploads().UpsertId(upload.Id, upload); err != nil {
+		return err
+	}
+	file.uploadSaved = true
+	return nil
+}
+
 func (file *GridFile) assertMode(mode gfsFileMode) {
 	switch file.mode {
 	case mode:
@@ -471,11 +1031,36 @@ func (file *GridFile) Size() (bytes int64) {
 	return
 }
 
-// MD5 returns the file MD5 as a hex-encoded string.
+// MD5 returns the file MD5 as a hex-encoded string, or an empty
+// string if the file was written without a checksum.
 func (file *GridFile) MD5() (md5 string) {
 	return file.doc.MD5
 }
 
+// Digest returns the algorithm and the hex-encoded digest of the file
+// content, or GridFSHashNone and an empty string if the file was written
+// without a digest. If the file holds several digests, the strongest one
+// is returned. See GridFS.SetHash.
+func (file *GridFile) Digest() (h GridFSHash, sum string) {
+	for _, h := range gfsHashes {
+		if sum := *file.doc.digest(h); sum != "" {
+			return h, sum
+		}
+	}
+	return GridFSHashNone, ""
+}
+
+// digest returns the field of doc holding the digest computed with h.
+func (doc *gfsFile) digest(h GridFSHash) *string {
+	switch h {
+	case GridFSHashSHA1:
+		return &doc.SHA1
+	case GridFSHashSHA256:
+		return &doc.SHA256
+	}
+	return &doc.MD5
+}
+
 // UploadDate returns the file upload time.
 func (file *GridFile) UploadDate() time.Time {
 	return file.doc.UploadDate
@@ -506,11 +1091,20 @@ func (file *GridFile) Close() (err error) {
 			file.insertChunk(file.wbuf)
 			file.wbuf = file.wbuf[0:0]
 		}
+		if len(file.wbatch) > 0 && file.err == nil {
+			file.flushBatch()
+		}
 		file.completeWrite()
-	} else if file.mode == gfsReading && file.rcache != nil {
-		file.rcache.wait.Lock()
+	} else if file.mode == gfsReading {
+		for _, cache := range file.rcache {
+			cache.wait.Lock()
+		}
 		file.rcache = nil
 	}
+	if file.session != nil {
+		file.session.Close()
+		file.session = nil
+	}
 	file.mode = gfsClosed
 	debugf("GridFile %p: closed", file)
 	return file.err
@@ -522,15 +1116,24 @@ func (file *GridFile) completeWrite() {
 		file.c.Wait()
 	}
 	if file.err == nil {
-		hexsum := hex.EncodeToString(file.wsum.Sum(nil))
 		if file.doc.UploadDate.IsZero() {
 			file.doc.UploadDate = bson.Now()
 		}
-		file.doc.MD5 = hexsum
+		if file.wsum != nil {
+			*file.doc.digest(file.whash) = hex.EncodeToString(file.wsum.Sum(nil))
+		}
 		file.err = file.gfs.Files.Insert(file.doc)
 	}
-	if file.err != nil {
-		file.gfs.Chunks.RemoveAll(bson.D{{"files_id", file.doc.Id}})
+	if file.err != nil && (!file.resumable || file.aborted) {
+		file.gfs.removeChunks(bson.D{{"files_id", file.doc.Id}})
+		if file.resumable {
+			file.gfs.uploads().RemoveId(file.doc.Id)
+		}
+	}
+	if file.err == nil && file.uploadSaved {
+		if err := file.gfs.uploads().RemoveId(file.doc.Id); err != ErrNotFound {
+			file.err = err
+		}
 	}
 	if file.err == nil {
 		index := Index{
@@ -539,6 +1142,9 @@ func (file *GridFile) completeWrite() {
 		}
 		file.err = file.gfs.Chunks.EnsureIndex(index)
 	}
+	if file.err == nil {
+		file.err = file.gfs.ensureFilesIndex()
+	}
 }
 
 // Abort cancels an in-progress write, preventing the file from being
@@ -552,6 +1158,7 @@ func (file *GridFile) Abort() {
 		panic("file.Abort must be called on file opened for writing")
 	}
 	file.err = errors.New("write aborted")
+	file.aborted = true
 }
 
 // Write writes the provided data to the file and returns the
@@ -613,35 +1220,82 @@ func (file *GridFile) Write(data []byte) (n int, err error) {
 }
 
 func (file *GridFile) insertChunk(data []byte) {
+	if file.resumable && !file.uploadSaved {
+		if file.err = file.saveUpload(); file.err != nil {
+			return
+		}
+	}
 	n := file.chunk
 	file.chunk++
-	debugf("GridFile %p: adding to checksum: %q", file, string(data))
-	file.wsum.Write(data)
+	if file.wsum != nil {
+		debugf("GridFile %p: adding to checksum: %q", file, string(data))
+		file.wsum.Write(data)
+	}
 
-	for file.doc.ChunkSize*file.wpending >= 1024*1024 {
-		// Hold on.. we got a MB pending.
-		file.c.Wait()
-		if file.err != nil {
+	var blobId string
+	if file.wdedup {
+		blobId = file.blobId(data)
+	}
+	if file.wcodec != nil {
+		var err error
+		if data, err = file.encode(data, file.chunkInfo(n, file.wdedup)); err != nil {
+			file.err = err
 			return
 		}
 	}
 
-	file.wpending++
-
-	debugf("GridFile %p: inserting chunk %d with %d bytes", file, n, len(data))
+	debugf("GridFile %p: queueing chunk %d with %d bytes", file, n, len(data))
 
 	// We may not own the memory of data, so rather than
 	// simply copying it, we'll marshal the document ahead of time.
-	data, err := bson.Marshal(gfsChunk{bson.NewObjectId(), file.doc.Id, n, data})
+	chunkId := bson.NewObjectId()
+	var doc []byte
+	var err error
+	if file.wdedup {
+		ref := gfsBlobRef{blobId, append([]byte(nil), data...)}
+		file.wblobs = append(file.wblobs, ref)
+		doc, err = bson.Marshal(gfsChunk{Id: chunkId, FilesId: file.doc.Id, N: n, Ref: blobId})
+	} else {
+		doc, err = bson.Marshal(gfsChunk{Id: chunkId, FilesId: file.doc.Id, N: n, Data: data})
+	}
 	if err != nil {
 		file.err = err
 		return
 	}
+	file.wbatch = append(file.wbatch, bson.Raw{Data: doc})
+	file.wbatchBytes += len(data)
+	if len(file.wbatch) >= file.wsettings.BatchChunks {
+		file.flushBatch()
+	}
+}
+
+// flushBatch inserts the queued chunks in background, once the
+// pending data and the insertions in progress are within limits.
+func (file *GridFile) flushBatch() {
+	batch, refs, size := file.wbatch, file.wblobs, file.wbatchBytes
+	file.wbatch, file.wblobs, file.wbatchBytes = nil, nil, 0
+
+	settings := &file.wsettings
+	for file.wpending > 0 && (file.wpendingBytes+size > settings.MaxPendingBytes ||
+		settings.MaxConcurrency > 0 && file.wpending >= settings.MaxConcurrency) {
+		// Hold on.. we got enough pending.
+		file.c.Wait()
+		if file.err != nil {
+			return
+		}
+	}
+
+	file.wpending++
+	file.wpendingBytes += size
 
+	debugf("GridFile %p: inserting %d chunks with %d bytes", file, len(batch), size)
+
+	retries := settings.MaxRetries
 	go func() {
-		err := file.gfs.Chunks.Insert(bson.Raw{Data: data})
+		err := file.insertBatch(batch, refs, retries)
 		file.m.Lock()
 		file.wpending--
+		file.wpendingBytes -= size
 		if err != nil && file.err == nil {
 			file.err = err
 		}
@@ -650,6 +1304,71 @@ func (file *GridFile) insertChunk(data []byte) {
 	}()
 }
 
+// insertBatch inserts the chunks in batch, retrying up to retries times
+// on a new connection. Chunks inserted by previous attempts are reported
+// as duplicates on retries, and are skipped. The deduplicated content in
+// refs is stored after the chunks referencing it. See releaseBlob.
+func (file *GridFile) insertBatch(batch []interface{}, refs []gfsBlobRef, retries int) error {
+	chunks := file.gfs.Chunks
+	err := chunks.Insert(batch...)
+	if err == nil {
+		err = storeBlobs(chunks, refs)
+	}
+	for attempt := 1; err != nil && attempt <= retries; attempt++ {
+		debugf("GridFile %p: retrying chunk insertion after error: %v", file, err)
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		session := chunks.Database.Session.Copy()
+		err = nil
+		for i := 0; err == nil && i < len(batch); i++ {
+			err = file.retryChunk(chunks.With(session), batch[i].(bson.Raw))
+		}
+		if err == nil {
+			err = storeBlobs(chunks.With(session), refs)
+		}
+		session.Close()
+	}
+	return err
+}
+
+// retryChunk inserts the chunk in doc unless it was inserted already.
+func (file *GridFile) retryChunk(chunks *Collection, doc bson.Raw) error {
+	err := chunks.Insert(doc)
+	if !IsDup(err) {
+		return err
+	}
+	var chunk gfsDocId
+	if uerr := doc.Unmarshal(&chunk); uerr != nil {
+		return uerr
+	}
+	if n, cerr := chunks.FindId(chunk.Id).Count(); cerr != nil || n == 0 {
+		// Another chunk has the same file id and number.
+		return err
+	}
+	return nil
+}
+
+// SetWriteSettings changes the settings for the insertion of the chunks
+// of the file. Zero fields in settings take their default values.
+//
+// It is a runtime error to call this function once the file has started
+// being written to, or when the file is not open for writing.
+func (file *GridFile) SetWriteSettings(settings GridFSWriteSettings) {
+	file.assertMode(gfsWriting)
+	file.m.Lock()
+	file.wsettings = normalizeWriteSettings(settings)
+	file.m.Unlock()
+}
+
+func normalizeWriteSettings(settings GridFSWriteSettings) GridFSWriteSettings {
+	if settings.MaxPendingBytes <= 0 {
+		settings.MaxPendingBytes = 1024 * 1024
+	}
+	if settings.BatchChunks <= 0 {
+		settings.BatchChunks = 1
+	}
+	return settings
+}
+
 // Seek sets the offset for the next Read or Write on file to
 // offset, interpreted according to whence: 0 means relative to
 // the origin of the file, 1 means relative to the current offset,
@@ -725,9 +1444,15 @@ func (file *GridFile) Read(b []byte) (n int, err error) {
 }
 
 func (file *GridFile) getChunk() (data []byte, err error) {
-	cache := file.rcache
-	file.rcache = nil
-	if cache != nil && cache.n == file.chunk {
+	var cache *gfsCachedChunk
+	if len(file.rcache) > 0 && file.rcache[0].n == file.chunk {
+		cache = file.rcache[0]
+		file.rcache = file.rcache[1:]
+	} else {
+		// Not reading sequentially. Pending fetches finish on their own.
+		file.rcache = nil
+	}
+	if cache != nil {
 		debugf("GridFile %p: Getting chunk %d from cache", file, file.chunk)
 		cache.wait.Lock()
 		data, err = cache.data, cache.err
@@ -735,27 +1460,154 @@ func (file *GridFile) getChunk() (data []byte, err error) {
 		debugf("GridFile %p: Fetching chunk %d", file, file.chunk)
 		var doc gfsChunk
 		err = file.gfs.Chunks.Find(bson.D{{"files_id", file.doc.Id}, {"n", file.chunk}}).One(&doc)
-		data = doc.Data
+		if err == nil {
+			data, err = file.chunkData(file.gfs.Chunks, &doc)
+		}
+	}
+	if err == nil {
+		err = file.checkDecoded(file.chunk, data)
 	}
 	file.chunk++
-	if int64(file.chunk)*int64(file.doc.ChunkSize) < file.doc.Length {
-		// Read the next one in background.
-		cache = &gfsCachedChunk{n: file.chunk}
+	file.scheduleReadAhead()
+	debugf("Returning err: %#v", err)
+	return
+}
+
+// scheduleReadAhead fetches in background the chunks following the current
+// one that are missing from the read-ahead window, with a single query.
+func (file *GridFile) scheduleReadAhead() {
+	first := file.chunk + len(file.rcache)
+	var caches []*gfsCachedChunk
+	for n := first; len(file.rcache) < file.readAhead && int64(n)*int64(file.doc.ChunkSize) < file.doc.Length; n++ {
+		cache := &gfsCachedChunk{n: n}
 		cache.wait.Lock()
-		debugf("GridFile %p: Scheduling chunk %d for background caching", file, file.chunk)
-		// Clone the session to avoid having it closed in between.
-		chunks := file.gfs.Chunks
-		session := chunks.Database.Session.Clone()
-		go func(id interface{}, n int) {
-			defer session.Close()
-			chunks = chunks.With(session)
+		caches = append(caches, cache)
+		file.rcache = append(file.rcache, cache)
+	}
+	if len(caches) == 0 {
+		return
+	}
+	debugf("GridFile %p: Scheduling chunks %d to %d for background caching", file, first, first+len(caches)-1)
+	// Clone the session to avoid having it closed in between.
+	chunks := file.gfs.Chunks
+	session := chunks.Database.Session.Clone()
+	go func(id interface{}) {
+		defer session.Close()
+		chunks = chunks.With(session)
+		var err error
+		if len(caches) == 1 {
 			var doc gfsChunk
-			cache.err = chunks.Find(bson.D{{"files_id", id}, {"n", n}}).One(&doc)
-			cache.data = doc.Data
+			err = chunks.Find(bson.D{{"files_id", id}, {"n", first}}).One(&doc)
+			if err == nil {
+				caches[0].data, err = file.chunkData(chunks, &doc)
+			}
+			caches[0].err = err
+			caches[0].wait.Unlock()
+			return
+		}
+		last := first + len(caches) - 1
+		iter := chunks.Find(bson.D{{"files_id", id}, {"n", bson.D{{"$gte", first}, {"$lte", last}}}}).Sort("n").Iter()
+		var doc gfsChunk
+		for iter.Next(&doc) && len(caches) > 0 {
+			if doc.N != caches[0].n {
+				break
+			}
+			caches[0].data, caches[0].err = file.chunkData(chunks, &doc)
+			caches[0].wait.Unlock()
+			caches = caches[1:]
+			doc = gfsChunk{}
+		}
+		err = iter.Close()
+		if err == nil {
+			err = ErrNotFound
+		}
+		for _, cache := range caches {
+			cache.err = err
 			cache.wait.Unlock()
-		}(file.doc.Id, file.chunk)
-		file.rcache = cache
+		}
+	}(file.doc.Id)
+}
+
+// SetReadAhead sets how many of the chunks following the one being read
+// are fetched in background while reading the file sequentially, with a
+// single query for all of them. The default is 1, and 0 disables it.
+//
+// It is a runtime error to call this function when the file is not open
+// for reading.
+func (file *GridFile) SetReadAhead(chunks int) {
+	file.assertMode(gfsReading)
+	file.m.Lock()
+	if chunks < 0 {
+		chunks = 0
 	}
-	debugf("Returning err: %#v", err)
-	return
+	file.readAhead = chunks
+	file.m.Unlock()
+}
+
+// ReadAt reads len(b) bytes from the file starting at offset off,
+// fetching all the chunks involved with a single query. It returns
+// the number of bytes read and an error, which is io.EOF if the end
+// of the file is reached before b is filled.
+//
+// ReadAt doesn't affect nor depend on the offset of Read and Seek,
+// and may be called concurrently from multiple goroutines, with
+// each other and with Read.
+//
+// The parameters and behavior of this function turn the file
+// into an io.ReaderAt.
+func (file *GridFile) ReadAt(b []byte, off int64) (n int, err error) {
+	file.assertMode(gfsReading)
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	length := file.doc.Length
+	if off >= length {
+		return 0, io.EOF
+	}
+	end := off + int64(len(b))
+	if end > length {
+		end = length
+	}
+	if end == off {
+		return 0, nil
+	}
+	chunkSize := int64(file.doc.ChunkSize)
+	first := int(off / chunkSize)
+	last := int((end - 1) / chunkSize)
+	debugf("GridFile %p: reading chunks %d to %d at offset %d", file, first, last, off)
+
+	query := bson.D{{"files_id", file.doc.Id}, {"n", bson.D{{"$gte", first}, {"$lte", last}}}}
+	iter := file.gfs.Chunks.Find(query).Sort("n").Iter()
+	want := first
+	var doc gfsChunk
+	for iter.Next(&doc) {
+		if doc.N != want {
+			break
+		}
+		data, err := file.chunkData(file.gfs.Chunks, &doc)
+		if err == nil {
+			err = file.checkDecoded(doc.N, data)
+		}
+		if err != nil {
+			iter.Close()
+			return n, err
+		}
+		skip := off + int64(n) - int64(doc.N)*chunkSize
+		if skip > int64(len(data)) {
+			break
+		}
+		n += copy(b[n:end-off], data[skip:])
+		want++
+		doc = gfsChunk{}
+	}
+	if err = iter.Close(); err != nil {
+		return n, err
+	}
+	if want <= last || int64(n) < end-off {
+		return n, fmt.Errorf("GridFS chunk %d of file %v is missing or truncated", want, file.doc.Id)
+	}
+	if end < off+int64(len(b)) {
+		return n, io.EOF
+	}
+	return n, nil
 }
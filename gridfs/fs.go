@@ -0,0 +1,193 @@
+//go:build go1.16
+// +build go1.16
+
+package gridfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// FS exposes the files of a GridFS as an fs.FS, which also implements
+// fs.ReadDirFS. File names are taken as "/" separated paths, with their
+// prefixes acting as virtual directories, so that "a/b.txt" is the file
+// b.txt in the directory a. The most recently uploaded file with a given
+// name is the one visible, and names that aren't valid paths as defined
+// by fs.ValidPath, such as "/a" or "a//b", are not visible at all. When a
+// name is both the name of a file and a directory prefix, the file wins.
+//
+// For example:
+//
+//     fsys := gridfs.NewFS(session.DB("mydb").GridFS("fs"))
+//     http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(fsys))))
+//
+type FS struct {
+	gfs *mgo.GridFS
+}
+
+// NewFS returns an FS exposing the files of gfs.
+func NewFS(gfs *mgo.GridFS) *FS {
+	return &FS{gfs}
+}
+
+var errNotDir = errors.New("not a directory")
+
+// Open implements fs.FS. Regular files returned implement io.Seeker
+// and io.ReaderAt, and directories implement fs.ReadDirFile.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		file, err := fsys.gfs.Open(name)
+		if err == nil {
+			return &fsFile{file, fileInfo(name, file.Size(), file.UploadDate())}, nil
+		}
+		if err != mgo.ErrNotFound {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	entries, err := fsys.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsDir{info: dirInfo(name), entries: entries}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		n, err := fsys.gfs.Find(bson.M{"filename": name}).Limit(1).Count()
+		if err == nil && n > 0 {
+			err = errNotDir
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+	}
+	entries, err := fsys.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// readDir returns the entries of the virtual directory name, sorted by
+// name, or fs.ErrNotExist if no file names are prefixed by it.
+func (fsys *FS) readDir(name string) ([]fs.DirEntry, error) {
+	var prefix string
+	query := bson.M{}
+	if name != "." {
+		prefix = name + "/"
+		query["filename"] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix)}
+	}
+	iter := fsys.gfs.Find(query).Select(bson.M{"filename": 1, "length": 1, "uploadDate": 1}).Sort("filename", "-uploadDate").Iter()
+	var doc struct {
+		Filename   string
+		Length     int64
+		UploadDate time.Time `bson:"uploadDate"`
+	}
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	for iter.Next(&doc) {
+		if !strings.HasPrefix(doc.Filename, prefix) || !fs.ValidPath(doc.Filename) {
+			continue
+		}
+		rest := doc.Filename[len(prefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if !seen[rest[:i]] {
+				seen[rest[:i]] = true
+				entries = append(entries, dirInfo(rest[:i]))
+			}
+		} else if !seen[rest] {
+			seen[rest] = true
+			entries = append(entries, fileInfo(rest, doc.Length, doc.UploadDate))
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, fs.ErrNotExist
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// info implements both fs.FileInfo and fs.DirEntry.
+type info struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func fileInfo(name string, size int64, modTime time.Time) *info {
+	return &info{path.Base(name), size, 0444, modTime}
+}
+
+func dirInfo(name string) *info {
+	return &info{path.Base(name), 0, fs.ModeDir | 0555, time.Time{}}
+}
+
+func (i *info) Name() string               { return i.name }
+func (i *info) Size() int64                { return i.size }
+func (i *info) Mode() fs.FileMode          { return i.mode }
+func (i *info) ModTime() time.Time         { return i.modTime }
+func (i *info) IsDir() bool                { return i.mode.IsDir() }
+func (i *info) Sys() interface{}           { return nil }
+func (i *info) Type() fs.FileMode          { return i.mode.Type() }
+func (i *info) Info() (fs.FileInfo, error) { return i, nil }
+
+type fsFile struct {
+	file *mgo.GridFile
+	info *info
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error)                   { return f.info, nil }
+func (f *fsFile) Read(b []byte) (int, error)                   { return f.file.Read(b) }
+func (f *fsFile) ReadAt(b []byte, off int64) (int, error)      { return f.file.ReadAt(b, off) }
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) { return f.file.Seek(offset, whence) }
+func (f *fsFile) Close() error                                 { return f.file.Close() }
+
+type fsDir struct {
+	info    *info
+	entries []fs.DirEntry
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,95 @@
+//go:build go1.16
+// +build go1.16
+
+package gridfs_test
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing/fstest"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable/gridfs"
+)
+
+func (s *S) createFile(c *C, name, content string, uploadDate time.Time) {
+	file, err := s.gfs.Create(name)
+	c.Assert(err, IsNil)
+	file.SetChunkSize(3)
+	file.SetUploadDate(uploadDate)
+	_, err = file.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+}
+
+func (s *S) TestFS(c *C) {
+	t := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.createFile(c, "a.txt", "old a", t)
+	s.createFile(c, "a.txt", "new a", t.Add(time.Hour))
+	s.createFile(c, "dir/b.txt", "b", t)
+	s.createFile(c, "dir/sub/c.txt", "c content", t)
+	s.createFile(c, "dir/sub/d.txt", "", t)
+	s.createFile(c, "/invalid", "x", t)
+	s.createFile(c, "a//invalid", "x", t)
+
+	fsys := gridfs.NewFS(s.gfs)
+	err := fstest.TestFS(fsys, "hello.txt", "a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/sub/d.txt")
+	c.Assert(err, IsNil)
+
+	data, err := fs.ReadFile(fsys, "a.txt")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "new a")
+
+	info, err := fs.Stat(fsys, "a.txt")
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, int64(5))
+	c.Assert(info.ModTime().Equal(t.Add(time.Hour)), Equals, true)
+
+	entries, err := fs.ReadDir(fsys, ".")
+	c.Assert(err, IsNil)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	c.Assert(names, DeepEquals, []string{"a.txt", "dir", "hello.txt"})
+
+	var walked []string
+	err = fs.WalkDir(fsys, "dir", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	c.Assert(err, IsNil)
+	c.Assert(walked, DeepEquals, []string{"dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt", "dir/sub/d.txt"})
+
+	_, err = fsys.Open("missing.txt")
+	c.Assert(err, ErrorMatches, "open missing.txt: file does not exist")
+	_, err = fsys.Open("/invalid")
+	c.Assert(err, ErrorMatches, "open /invalid: invalid argument")
+	_, err = fsys.ReadDir("a.txt")
+	c.Assert(err, ErrorMatches, "readdir a.txt: not a directory")
+}
+
+func (s *S) TestFSFileServer(c *C) {
+	s.createFile(c, "dir/b.txt", "b content", time.Now())
+
+	server := httptest.NewServer(http.FileServer(http.FS(gridfs.NewFS(s.gfs))))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/dir/b.txt")
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "b content")
+
+	resp, err = http.Get(server.URL + "/dir/")
+	c.Assert(err, IsNil)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Matches, `(?s).*<a href="b.txt">b.txt</a>.*`)
+}
@@ -0,0 +1,103 @@
+// The gridfs package serves files stored in MongoDB GridFS over HTTP,
+// with support for range and conditional requests.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/gridfs/
+//
+package gridfs
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// Handler serves the files of a GridFS over HTTP, looking them up by the
+// request path with the leading slash removed. Use http.StripPrefix to
+// serve files under a path prefix.
+//
+// For example:
+//
+//     handler := &gridfs.Handler{GridFS: session.DB("mydb").GridFS("fs")}
+//     http.Handle("/files/", http.StripPrefix("/files/", handler))
+//
+type Handler struct {
+	// GridFS holds the files served. Its session is copied
+	// for the handling of every request.
+	GridFS *mgo.GridFS
+
+	// ById causes the request path to be taken as the hex
+	// representation of the ObjectId of the file, rather than
+	// as its name. Files with other kinds of ids can't be served.
+	ById bool
+}
+
+// ServeHTTP implements http.Handler. The most recently uploaded file
+// with the requested name is served, unless ById is set. Only the
+// GET and HEAD methods are allowed.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := h.GridFS.Files.Database.Session.Copy()
+	defer session.Close()
+	gfs := h.GridFS.With(session)
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	var file *mgo.GridFile
+	var err error
+	if !h.ById {
+		file, err = gfs.Open(name)
+	} else if bson.IsObjectIdHex(name) {
+		file, err = gfs.OpenId(bson.ObjectIdHex(name))
+	} else {
+		err = mgo.ErrNotFound
+	}
+	if err == mgo.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	ServeFile(w, r, file)
+}
+
+// ServeFile replies to the request with the content of file, which must be
+// open for reading. The Content-Type header is set from the content type of
+// the file, or inferred from its name or content if unset. The ETag header
+// is set from the digest of the file, or from its id if the digest
+// is unavailable, and the Last-Modified header from its upload date, so that
+// conditional requests are handled. Range and HEAD requests are handled too.
+//
+// See http.ServeContent for details.
+func ServeFile(w http.ResponseWriter, r *http.Request, file *mgo.GridFile) {
+	header := w.Header()
+	if ctype := file.ContentType(); ctype != "" {
+		header.Set("Content-Type", ctype)
+	}
+	if header.Get("Etag") == "" {
+		header.Set("Etag", ETag(file))
+	}
+	http.ServeContent(w, r, file.Name(), file.UploadDate(), file)
+}
+
+// ETag returns the strong entity tag used for file by ServeFile.
+func ETag(file *mgo.GridFile) string {
+	if _, sum := file.Digest(); sum != "" {
+		return `"` + sum + `"`
+	}
+	if id, ok := file.Id().(bson.ObjectId); ok {
+		return `"` + id.Hex() + `"`
+	}
+	return fmt.Sprintf("%q", fmt.Sprint(file.Id()))
+}
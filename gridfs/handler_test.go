@@ -0,0 +1,161 @@
+package gridfs_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+	"gopkg.in/mgo.v2-unstable/gridfs"
+)
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+// S holds the tests that run against the rs1 replica set
+// started by the test harness.
+type S struct {
+	session *mgo.Session
+	gfs     *mgo.GridFS
+	file    *mgo.GridFile
+}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpTest(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	s.session = session
+	s.gfs = session.DB("gridfstest").GridFS("fs")
+
+	file, err := s.gfs.Create("hello.txt")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(4)
+	file.SetContentType("text/plain; charset=utf-8")
+	file.SetUploadDate(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, err = file.Write([]byte("Hello, world!"))
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+	s.file = file
+}
+
+func (s *S) TearDownTest(c *C) {
+	err := s.session.DB("gridfstest").DropDatabase()
+	c.Assert(err, IsNil)
+	s.session.Close()
+}
+
+func (s *S) serve(c *C, h http.Handler, method, path string, header map[string]string) (*httptest.ResponseRecorder, string) {
+	req, err := http.NewRequest(method, path, nil)
+	c.Assert(err, IsNil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, err := ioutil.ReadAll(rec.Body)
+	c.Assert(err, IsNil)
+	return rec, string(body)
+}
+
+func (s *S) TestServeByName(c *C) {
+	h := &gridfs.Handler{GridFS: s.gfs}
+	rec, body := s.serve(c, h, "GET", "/hello.txt", nil)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "Hello, world!")
+	c.Assert(rec.Header().Get("Content-Type"), Equals, "text/plain; charset=utf-8")
+	c.Assert(rec.Header().Get("Content-Length"), Equals, "13")
+	c.Assert(rec.Header().Get("Etag"), Equals, `"6cd3556deb0da54bca060b4c39479839"`)
+	c.Assert(rec.Header().Get("Last-Modified"), Equals, "Thu, 02 Jan 2020 03:04:05 GMT")
+	c.Assert(rec.Header().Get("Accept-Ranges"), Equals, "bytes")
+
+	rec, body = s.serve(c, h, "HEAD", "/hello.txt", nil)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "")
+	c.Assert(rec.Header().Get("Content-Length"), Equals, "13")
+
+	rec, _ = s.serve(c, h, "GET", "/missing.txt", nil)
+	c.Assert(rec.Code, Equals, http.StatusNotFound)
+
+	rec, _ = s.serve(c, h, "POST", "/hello.txt", nil)
+	c.Assert(rec.Code, Equals, http.StatusMethodNotAllowed)
+	c.Assert(rec.Header().Get("Allow"), Equals, "GET, HEAD")
+}
+
+func (s *S) TestServeById(c *C) {
+	h := http.StripPrefix("/files/", &gridfs.Handler{GridFS: s.gfs, ById: true})
+	id := s.file.Id().(bson.ObjectId)
+	rec, body := s.serve(c, h, "GET", "/files/"+id.Hex(), nil)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "Hello, world!")
+
+	rec, _ = s.serve(c, h, "GET", "/files/hello.txt", nil)
+	c.Assert(rec.Code, Equals, http.StatusNotFound)
+}
+
+func (s *S) TestServeRange(c *C) {
+	h := &gridfs.Handler{GridFS: s.gfs}
+	rec, body := s.serve(c, h, "GET", "/hello.txt", map[string]string{"Range": "bytes=7-11"})
+	c.Assert(rec.Code, Equals, http.StatusPartialContent)
+	c.Assert(body, Equals, "world")
+	c.Assert(rec.Header().Get("Content-Range"), Equals, "bytes 7-11/13")
+
+	rec, body = s.serve(c, h, "GET", "/hello.txt", map[string]string{"Range": "bytes=-6"})
+	c.Assert(rec.Code, Equals, http.StatusPartialContent)
+	c.Assert(body, Equals, "world!")
+
+	rec, _ = s.serve(c, h, "GET", "/hello.txt", map[string]string{"Range": "bytes=20-"})
+	c.Assert(rec.Code, Equals, http.StatusRequestedRangeNotSatisfiable)
+
+	// If-Range with a matching ETag honors the range, otherwise
+	// the whole file is served.
+	etag := gridfs.ETag(s.file)
+	rec, body = s.serve(c, h, "GET", "/hello.txt", map[string]string{"Range": "bytes=0-4", "If-Range": etag})
+	c.Assert(rec.Code, Equals, http.StatusPartialContent)
+	c.Assert(body, Equals, "Hello")
+	rec, body = s.serve(c, h, "GET", "/hello.txt", map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`})
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "Hello, world!")
+}
+
+func (s *S) TestServeConditional(c *C) {
+	h := &gridfs.Handler{GridFS: s.gfs}
+	etag := gridfs.ETag(s.file)
+
+	rec, body := s.serve(c, h, "GET", "/hello.txt", map[string]string{"If-None-Match": etag})
+	c.Assert(rec.Code, Equals, http.StatusNotModified)
+	c.Assert(body, Equals, "")
+
+	rec, _ = s.serve(c, h, "GET", "/hello.txt", map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 03:04:05 GMT"})
+	c.Assert(rec.Code, Equals, http.StatusNotModified)
+
+	rec, _ = s.serve(c, h, "GET", "/hello.txt", map[string]string{"If-Modified-Since": "Wed, 01 Jan 2020 00:00:00 GMT"})
+	c.Assert(rec.Code, Equals, http.StatusOK)
+
+	rec, _ = s.serve(c, h, "GET", "/hello.txt", map[string]string{"If-Match": `"other"`})
+	c.Assert(rec.Code, Equals, http.StatusPreconditionFailed)
+}
+
+func (s *S) TestETagWithoutMD5(c *C) {
+	bucket := s.session.DB("gridfstest").GridFSBucket(&mgo.GridFSBucketOptions{DisableMD5: true})
+	file, err := bucket.OpenUploadStreamWithId(42, "nomd5.txt", nil)
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("data"))
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	file, err = bucket.OpenDownloadStream(42)
+	c.Assert(err, IsNil)
+	defer file.Close()
+	c.Assert(gridfs.ETag(file), Equals, `"42"`)
+
+	rec, body := s.serve(c, &gridfs.Handler{GridFS: s.gfs}, "GET", "/nomd5.txt", nil)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "data")
+	c.Assert(rec.Header().Get("Etag"), Equals, `"42"`)
+}
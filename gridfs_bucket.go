@@ -0,0 +1,255 @@
+package mgo
+
+import (
+	"sync"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// GridFSBucket provides access to files stored in a database as defined
+// by the current GridFS specification, which makes the MD5 checksum of
+// files optional and allows reading specific revisions of a file name.
+//
+// The collections used are the same as those of GridFS, so files written
+// via GridFSBucket may be read via GridFS, and vice versa.
+//
+// Relevant documentation:
+//
+//     https://github.com/mongodb/specifications/blob/master/source/gridfs/gridfs-spec.rst
+//
+type GridFSBucket struct {
+	Files  *Collection
+	Chunks *Collection
+
+	opts GridFSBucketOptions
+
+	m       sync.Mutex
+	indexed bool
+}
+
+// GridFSBucketOptions holds the settings of a GridFSBucket.
+type GridFSBucketOptions struct {
+	// Name is the prefix of the files and chunks collections
+	// of the bucket. Defaults to "fs".
+	Name string
+
+	// ChunkSize is the default size in bytes of the chunks of
+	// uploaded files. Defaults to 255kb.
+	ChunkSize int
+
+	// Safe, if set, overrides the safety settings of the database
+	// session for the changes made via the bucket. See Session.SetSafe.
+	Safe *Safe
+
+	// DisableMD5 prevents computing and storing the MD5 checksum
+	// of uploaded files.
+	DisableMD5 bool
+
+	// Hash selects the algorithm used to compute the digest of
+	// uploaded files, overriding DisableMD5. See GridFS.SetHash.
+	Hash GridFSHash
+
+	// WriteSettings holds the settings for the insertion of the
+	// chunks of uploaded files. See GridFile.SetWriteSettings.
+	WriteSettings GridFSWriteSettings
+}
+
+// GridFSUploadOptions holds the settings of an individual upload.
+type GridFSUploadOptions struct {
+	// ChunkSize overrides the chunk size set for the bucket.
+	ChunkSize int
+
+	// ContentType is stored as the contentType field of the file.
+	ContentType string
+
+	// Metadata is stored as the metadata field of the file.
+	Metadata interface{}
+}
+
+// GridFSBucket returns the GridFS bucket in db with the provided
+// options, which may be nil for the defaults.
+func (db *Database) GridFSBucket(opts *GridFSBucketOptions) *GridFSBucket {
+	b := &GridFSBucket{}
+	if opts != nil {
+		b.opts = *opts
+	}
+	if b.opts.Name == "" {
+		b.opts.Name = "fs"
+	}
+	if b.opts.ChunkSize <= 0 {
+		b.opts.ChunkSize = 255 * 1024
+	}
+	b.Files = db.C(b.opts.Name + ".files")
+	b.Chunks = db.C(b.opts.Name + ".chunks")
+	return b
+}
+
+// gfs returns a GridFS for the bucket collections using a clone of the
+// database session with the safety settings of the bucket applied.
+// The returned session must be closed after use.
+func (b *GridFSBucket) gfs() (*GridFS, *Session) {
+	session := b.Files.Database.Session.Clone()
+	if b.opts.Safe != nil {
+		session.SetSafe(b.opts.Safe)
+	}
+	return &GridFS{Files: b.Files.With(session), Chunks: b.Chunks.With(session)}, session
+}
+
+// ensureIndexes creates the indexes the specification requires
+// before the first file is uploaded via the bucket.
+func (b *GridFSBucket) ensureIndexes(gfs *GridFS) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.indexed {
+		return nil
+	}
+	err := gfs.Files.EnsureIndex(Index{Key: []string{"filename", "uploadDate"}})
+	if err == nil {
+		err = gfs.Chunks.EnsureIndex(Index{Key: []string{"files_id", "n"}, Unique: true})
+	}
+	if err == nil {
+		b.indexed = true
+	}
+	return err
+}
+
+// OpenUploadStream returns a new file with the provided name, for writing,
+// with a new ObjectId as its id. The file is only visible in the bucket
+// once it's closed with no errors. The opts may be nil for the defaults.
+//
+// For example:
+//
+//     bucket := db.GridFSBucket(&mgo.GridFSBucketOptions{DisableMD5: true})
+//     file, err := bucket.OpenUploadStream("myfile.txt", nil)
+//     check(err)
+//     _, err = io.Copy(file, src)
+//     check(err)
+//     err = file.Close()
+//     check(err)
+//
+func (b *GridFSBucket) OpenUploadStream(name string, opts *GridFSUploadOptions) (*GridFile, error) {
+	return b.OpenUploadStreamWithId(bson.NewObjectId(), name, opts)
+}
+
+// OpenUploadStreamWithId works like OpenUploadStream, but uses
+// the provided id for the new file.
+func (b *GridFSBucket) OpenUploadStreamWithId(id interface{}, name string, opts *GridFSUploadOptions) (*GridFile, error) {
+	gfs, session := b.gfs()
+	if err := b.ensureIndexes(gfs); err != nil {
+		session.Close()
+		return nil, err
+	}
+	file := gfs.newFile()
+	file.session = session
+	hash := b.opts.Hash
+	if hash == "" && b.opts.DisableMD5 {
+		hash = GridFSHashNone
+	}
+	if err := file.newWriter(hash); err != nil {
+		session.Close()
+		return nil, err
+	}
+	file.doc = gfsFile{Id: id, ChunkSize: b.opts.ChunkSize, Filename: name}
+	file.wsettings = normalizeWriteSettings(b.opts.WriteSettings)
+	if opts != nil {
+		if opts.ChunkSize > 0 {
+			file.doc.ChunkSize = opts.ChunkSize
+		}
+		file.doc.ContentType = opts.ContentType
+		if opts.Metadata != nil {
+			file.SetMeta(opts.Metadata)
+		}
+	}
+	return file, nil
+}
+
+// OpenDownloadStream returns the file with the provided id, for reading.
+// If the file isn't found, err will be set to ErrNotFound.
+func (b *GridFSBucket) OpenDownloadStream(id interface{}) (*GridFile, error) {
+	return b.openFile(b.Files.FindId(id))
+}
+
+// OpenDownloadStreamByName returns a revision of the file with the provided
+// name, for reading. Revisions are numbered by upload date, with 0 being the
+// original file, 1 the first revision, and so on, while -1 is the most
+// recent revision, -2 the one before it, and so on. If the revision isn't
+// found, err will be set to ErrNotFound.
+func (b *GridFSBucket) OpenDownloadStreamByName(name string, revision int) (*GridFile, error) {
+	query := b.Files.Find(bson.M{"filename": name})
+	if revision >= 0 {
+		query = query.Sort("uploadDate").Skip(revision)
+	} else {
+		query = query.Sort("-uploadDate").Skip(-revision - 1)
+	}
+	return b.openFile(query)
+}
+
+func (b *GridFSBucket) openFile(query *Query) (*GridFile, error) {
+	var doc gfsFile
+	if err := query.One(&doc); err != nil {
+		return nil, err
+	}
+	file := (&GridFS{Files: b.Files, Chunks: b.Chunks}).newFile()
+	file.mode = gfsReading
+	file.doc = doc
+	return file, nil
+}
+
+// OpenNext works like GridFS.OpenNext, for an iterator
+// on the files collection of the bucket.
+func (b *GridFSBucket) OpenNext(iter *Iter, file **GridFile) bool {
+	return (&GridFS{Files: b.Files, Chunks: b.Chunks}).OpenNext(iter, file)
+}
+
+// Find runs query on the files collection of the bucket
+// and returns the resulting Query.
+func (b *GridFSBucket) Find(query interface{}) *Query {
+	return b.Files.Find(query)
+}
+
+// Rename changes the name of the file with the provided id. If the
+// file isn't found, err will be set to ErrNotFound.
+func (b *GridFSBucket) Rename(id interface{}, newName string) error {
+	gfs, session := b.gfs()
+	defer session.Close()
+	return gfs.Files.UpdateId(id, bson.M{"$set": bson.M{"filename": newName}})
+}
+
+// Delete deletes the file with the provided id and its chunks. If the
+// file isn't found, any orphan chunks with the id are still deleted
+// and err will be set to ErrNotFound.
+func (b *GridFSBucket) Delete(id interface{}) error {
+	gfs, session := b.gfs()
+	defer session.Close()
+	err := gfs.Files.RemoveId(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if _, cerr := gfs.removeChunks(bson.D{{"files_id", id}}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// Drop removes the files and chunks collections of the bucket, and the
+// collection holding deduplicated content if any (see GridFS.SetDedup),
+// and with them all of its files.
+func (b *GridFSBucket) Drop() error {
+	gfs, session := b.gfs()
+	defer session.Close()
+	for _, coll := range []*Collection{gfs.Files, gfs.Chunks, blobs(gfs.Chunks)} {
+		if err := coll.DropCollection(); err != nil && !isNsNotFound(err) {
+			return err
+		}
+	}
+	b.m.Lock()
+	b.indexed = false
+	b.m.Unlock()
+	return nil
+}
+
+func isNsNotFound(err error) bool {
+	e, ok := err.(*QueryError)
+	return ok && (e.Code == 26 || e.Message == "ns not found")
+}
+
@@ -0,0 +1,224 @@
+package mgo_test
+
+import (
+	"io/ioutil"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+func (s *S) TestGridFSBucketUpload(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	bucket := db.GridFSBucket(&mgo.GridFSBucketOptions{
+		Name:       "images",
+		ChunkSize:  4,
+		DisableMD5: true,
+		Safe:       &mgo.Safe{W: 1, J: true},
+	})
+	file, err := bucket.OpenUploadStream("myfile.txt", &mgo.GridFSUploadOptions{
+		ContentType: "text/plain",
+		Metadata:    bson.M{"owner": "ann"},
+	})
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("abcdefghij"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	result := M{}
+	err = db.C("images.files").FindId(file.Id()).One(result)
+	c.Assert(err, IsNil)
+	_, ok := result["md5"]
+	c.Assert(ok, Equals, false)
+	c.Assert(result["length"], Equals, 10)
+	c.Assert(result["chunkSize"], Equals, 4)
+	c.Assert(result["contentType"], Equals, "text/plain")
+	c.Assert(result["metadata"], DeepEquals, M{"owner": "ann"})
+
+	n, err := db.C("images.chunks").Find(M{"files_id": file.Id()}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	indexes, err := db.C("images.files").Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes, HasLen, 2)
+	c.Assert(indexes[1].Key, DeepEquals, []string{"filename", "uploadDate"})
+
+	file, err = bucket.OpenDownloadStream(file.Id())
+	c.Assert(err, IsNil)
+	c.Assert(file.MD5(), Equals, "")
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abcdefghij")
+	var meta struct{ Owner string }
+	c.Assert(file.GetMeta(&meta), IsNil)
+	c.Assert(meta.Owner, Equals, "ann")
+	c.Assert(file.Close(), IsNil)
+
+	_, err = bucket.OpenDownloadStream(bson.NewObjectId())
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestGridFSBucketUnknownHash(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	bucket := session.DB("mydb").GridFSBucket(&mgo.GridFSBucketOptions{Hash: "crc32"})
+	_, err = bucket.OpenUploadStream("myfile.txt", nil)
+	c.Assert(err, ErrorMatches, "unknown GridFS hash algorithm: crc32")
+}
+
+func (s *S) TestGridFSBucketCompatibility(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	gfs := db.GridFS("fs")
+	file, err := gfs.Create("old.txt")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("old data"))
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	bucket := db.GridFSBucket(nil)
+	file, err = bucket.OpenDownloadStreamByName("old.txt", -1)
+	c.Assert(err, IsNil)
+	c.Assert(file.MD5(), Equals, "2bf6456b87a5af08343b78e711bf8e17")
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "old data")
+	c.Assert(file.Close(), IsNil)
+
+	file, err = bucket.OpenUploadStream("new.txt", nil)
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("new data"))
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	file, err = gfs.Open("new.txt")
+	c.Assert(err, IsNil)
+	c.Assert(file.MD5(), Not(Equals), "")
+	data, err = ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "new data")
+	c.Assert(file.Close(), IsNil)
+}
+
+func (s *S) TestGridFSBucketRevisions(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	bucket := session.DB("mydb").GridFSBucket(nil)
+	for _, content := range []string{"0", "1", "2"} {
+		file, err := bucket.OpenUploadStream("myfile.txt", nil)
+		c.Assert(err, IsNil)
+		_, err = file.Write([]byte(content))
+		c.Assert(err, IsNil)
+		c.Assert(file.Close(), IsNil)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	revisions := []struct {
+		revision int
+		content  string
+	}{{0, "0"}, {1, "1"}, {2, "2"}, {-1, "2"}, {-2, "1"}, {-3, "0"}}
+	for _, r := range revisions {
+		file, err := bucket.OpenDownloadStreamByName("myfile.txt", r.revision)
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(file)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, r.content, Commentf("revision %d", r.revision))
+		c.Assert(file.Close(), IsNil)
+	}
+
+	_, err = bucket.OpenDownloadStreamByName("myfile.txt", 3)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	_, err = bucket.OpenDownloadStreamByName("myfile.txt", -4)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	_, err = bucket.OpenDownloadStreamByName("other.txt", -1)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestGridFSBucketRenameDeleteDrop(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	bucket := db.GridFSBucket(&mgo.GridFSBucketOptions{ChunkSize: 2})
+	var ids []interface{}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file, err := bucket.OpenUploadStream(name, nil)
+		c.Assert(err, IsNil)
+		_, err = file.Write([]byte("data"))
+		c.Assert(err, IsNil)
+		c.Assert(file.Close(), IsNil)
+		ids = append(ids, file.Id())
+	}
+
+	err = bucket.Rename(ids[0], "c.txt")
+	c.Assert(err, IsNil)
+	n, err := bucket.Find(M{"filename": "c.txt"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	err = bucket.Rename(bson.NewObjectId(), "d.txt")
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	err = bucket.Delete(ids[0])
+	c.Assert(err, IsNil)
+	n, err = db.C("fs.chunks").Find(M{"files_id": ids[0]}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	err = bucket.Delete(ids[0])
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	// Deduplicated content is released.
+	gfs := db.GridFS("fs")
+	gfs.SetDedup(true)
+	file, err := gfs.Create("dedup.txt")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("data"))
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+	n, err = db.C("fs.blobs").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	err = bucket.Delete(file.Id())
+	c.Assert(err, IsNil)
+	n, err = db.C("fs.blobs").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	var f *mgo.GridFile
+	iter := bucket.Find(nil).Iter()
+	c.Assert(bucket.OpenNext(iter, &f), Equals, true)
+	c.Assert(f.Name(), Equals, "b.txt")
+	c.Assert(bucket.OpenNext(iter, &f), Equals, false)
+	c.Assert(iter.Close(), IsNil)
+
+	// Dropped along with the bucket.
+	file, err = gfs.Create("dedup.txt")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("data"))
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	err = bucket.Drop()
+	c.Assert(err, IsNil)
+	names, err := db.CollectionNames()
+	c.Assert(err, IsNil)
+	for _, name := range names {
+		c.Assert(name, Not(Matches), "fs\\..*")
+	}
+	err = bucket.Drop()
+	c.Assert(err, IsNil)
+}
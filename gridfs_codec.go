@@ -0,0 +1,431 @@
+package mgo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// GridFSCodec transforms the data of GridFS chunks as stored in the
+// database, compressing or encrypting it for example. Codecs must be
+// safe for concurrent use, as chunks are decoded in background while
+// files are read. See GridFS.SetCodec.
+type GridFSCodec interface {
+	// Name identifies the codec in the documents of the files
+	// written with it, so that they may be decoded when read.
+	Name() string
+
+	// Encode returns the data of a chunk as it must be stored.
+	// It must not modify nor retain data.
+	Encode(data []byte) ([]byte, error)
+
+	// Decode returns the original data of a chunk as stored.
+	Decode(data []byte) ([]byte, error)
+}
+
+// GridFSChunkCodec is a GridFSCodec whose transformation depends on the
+// chunk being transformed. The EncodeChunk and DecodeChunk methods are
+// used in place of Encode and Decode for the chunks of files.
+type GridFSChunkCodec interface {
+	GridFSCodec
+
+	// EncodeChunk works like Encode for the provided chunk.
+	EncodeChunk(data []byte, chunk *GridFSChunk) ([]byte, error)
+
+	// DecodeChunk works like Decode for the provided chunk, and must
+	// fail rather than return more than chunk.MaxSize bytes.
+	DecodeChunk(data []byte, chunk *GridFSChunk) ([]byte, error)
+}
+
+// GridFSChunk identifies the chunk of a file a GridFSChunkCodec
+// transforms the data of.
+type GridFSChunk struct {
+	// FilesId and N hold the id of the file and the number of the
+	// chunk in the file. Deduplicated chunks may be shared by several
+	// files, so FilesId is nil and N is zero for them. See SetDedup.
+	FilesId interface{}
+	N       int
+
+	// MaxSize holds the maximum size of the decoded data.
+	MaxSize int
+}
+
+// SetCodec sets the codec the data of the files created via gfs from now
+// on is transformed with, before being stored. The file documents record
+// the name of the codec, so files written with it may be read again via
+// gfs, or via any GridFS value that the codec was set on, while files
+// written without a codec continue to be read normally. Files written
+// with the gzip codec are always readable. A nil codec disables the
+// transformation of new files.
+//
+// Each chunk holds ChunkSize bytes of the content once decoded, as
+// usual, so the file Length and seeking into the file are unaffected:
+// the chunk holding an offset is found as for any other file, and no
+// index of the offsets of chunks is stored. Reading a chunk that doesn't
+// decode to the expected size fails rather than returning content from
+// the wrong offset.
+// Codecs implementing GridFSChunkCodec are told which chunk they
+// transform, which the AES codec binds the encrypted data to.
+//
+// For example, the following snippet compresses and then encrypts the
+// files written, with keys obtained from a key management system:
+//
+//     gfs := db.GridFS("fs")
+//     gfs.SetCodec(mgo.GridFSCodecChain(
+//         mgo.NewGridFSGzipCodec(gzip.DefaultCompression),
+//         mgo.NewGridFSAESCodec("key1", kms.Key),
+//     ))
+//
+func (gfs *GridFS) SetCodec(codec GridFSCodec) {
+	gfs.codec = codec
+	if codec == nil {
+		return
+	}
+	codecs := make(map[string]GridFSCodec, len(gfs.codecs)+1)
+	for name, c := range gfs.codecs {
+		codecs[name] = c
+	}
+	codecs[codec.Name()] = codec
+	gfs.codecs = codecs
+}
+
+// codecFor returns the codec with the provided name, or nil if the
+// name is empty.
+func (gfs *GridFS) codecFor(name string) (GridFSCodec, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if codec, ok := gfs.codecs[name]; ok {
+		return codec, nil
+	}
+	if name == gfsGzipName {
+		return NewGridFSGzipCodec(gzip.DefaultCompression), nil
+	}
+	return nil, fmt.Errorf("unknown GridFS codec %q", name)
+}
+
+// chunkInfo returns the details of chunk n of file provided to
+// chunk codecs, or of a deduplicated chunk if shared is true.
+func (file *GridFile) chunkInfo(n int, shared bool) *GridFSChunk {
+	if shared {
+		return &GridFSChunk{MaxSize: file.doc.ChunkSize}
+	}
+	return &GridFSChunk{FilesId: file.doc.Id, N: n, MaxSize: file.doc.ChunkSize}
+}
+
+// encode returns the content of a chunk of file as it must be stored.
+func (file *GridFile) encode(data []byte, chunk *GridFSChunk) ([]byte, error) {
+	if codec, ok := file.wcodec.(GridFSChunkCodec); ok {
+		return codec.EncodeChunk(data, chunk)
+	}
+	return file.wcodec.Encode(data)
+}
+
+// decode returns the original content of a chunk of file.
+func (file *GridFile) decode(data []byte, chunk *GridFSChunk) ([]byte, error) {
+	if file.doc.Codec == "" {
+		return data, nil
+	}
+	codec, err := file.gfs.codecFor(file.doc.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read GridFS file %v: %v", file.doc.Id, err)
+	}
+	if codec, ok := codec.(GridFSChunkCodec); ok {
+		return codec.DecodeChunk(data, chunk)
+	}
+	return codec.Decode(data)
+}
+
+// checkDecoded returns an error unless the content of encoded chunk n
+// of file has the size the offsets in the file are computed from, as
+// the stored size of encoded chunks varies.
+func (file *GridFile) checkDecoded(n int, data []byte) error {
+	if file.doc.Codec == "" {
+		return nil
+	}
+	size := int64(file.doc.ChunkSize)
+	if rest := file.doc.Length - int64(n)*size; rest < size {
+		size = rest
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("GridFS chunk %d of file %v decodes to %d bytes rather than %d", n, file.doc.Id, len(data), size)
+	}
+	return nil
+}
+
+// gfsMaxDecodedSize bounds the data decoded when the chunk isn't known,
+// and the intermediate results of codec chains.
+const gfsMaxDecodedSize = defaultMaxBsonObjectSize
+
+var errGridFSChunkSize = errors.New("GridFS chunk data exceeds the chunk size")
+
+const gfsGzipName = "gzip"
+
+type gfsGzipCodec struct {
+	level int
+}
+
+// NewGridFSGzipCodec returns a GridFS codec compressing chunks with gzip
+// at the provided compression level, as defined by the gzip package.
+// Decompressing a chunk fails if it would exceed the chunk size.
+//
+// No zstd codec is provided, as the standard library has no zstd
+// implementation and the driver has no third-party dependencies.
+// One may be plugged in by implementing GridFSCodec.
+func NewGridFSGzipCodec(level int) GridFSCodec {
+	return gfsGzipCodec{level}
+}
+
+func (c gfsGzipCodec) Name() string {
+	return gfsGzipName
+}
+
+func (c gfsGzipCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gfsGzipCodec) Decode(data []byte) ([]byte, error) {
+	return c.DecodeChunk(data, &GridFSChunk{MaxSize: gfsMaxDecodedSize})
+}
+
+func (c gfsGzipCodec) EncodeChunk(data []byte, chunk *GridFSChunk) ([]byte, error) {
+	return c.Encode(data)
+}
+
+// DecodeChunk reads at most one byte past chunk.MaxSize, so that
+// corrupted or malicious data can't exhaust the memory.
+func (c gfsGzipCodec) DecodeChunk(data []byte, chunk *GridFSChunk) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(chunk.MaxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > chunk.MaxSize {
+		return nil, errGridFSChunkSize
+	}
+	return out, nil
+}
+
+type gfsAESCodec struct {
+	keyId string
+	keys  func(keyId string) ([]byte, error)
+
+	m     sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+// NewGridFSAESCodec returns a GridFS codec encrypting chunks with AES-GCM,
+// using the key with the provided id. The keys function is called to
+// obtain the key with a given id, of 16, 24 or 32 bytes, once per id.
+//
+// The key id is stored with each chunk, so that keys may be rotated by
+// setting a codec with a new key id while files encrypted with former
+// keys remain readable, as long as keys still provides them.
+//
+// The chunks of files are authenticated along with the file id and the
+// chunk number, so chunks moved or copied into another file or position
+// fail to decrypt. Note that the length of files and the order of their
+// chunks aren't encrypted.
+func NewGridFSAESCodec(keyId string, keys func(keyId string) ([]byte, error)) GridFSCodec {
+	if len(keyId) > 255 {
+		panic("GridFS AES key id is too long")
+	}
+	return &gfsAESCodec{keyId: keyId, keys: keys, aeads: make(map[string]cipher.AEAD)}
+}
+
+func (c *gfsAESCodec) Name() string {
+	return "aes-gcm"
+}
+
+func (c *gfsAESCodec) aead(keyId string) (cipher.AEAD, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if aead, ok := c.aeads[keyId]; ok {
+		return aead, nil
+	}
+	key, err := c.keys(keyId)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[keyId] = aead
+	return aead, nil
+}
+
+func (c *gfsAESCodec) Encode(data []byte) ([]byte, error) {
+	return c.seal(data, nil)
+}
+
+func (c *gfsAESCodec) Decode(data []byte) ([]byte, error) {
+	return c.open(data, nil)
+}
+
+func (c *gfsAESCodec) EncodeChunk(data []byte, chunk *GridFSChunk) ([]byte, error) {
+	ad, err := chunkAD(chunk)
+	if err != nil {
+		return nil, err
+	}
+	return c.seal(data, ad)
+}
+
+func (c *gfsAESCodec) DecodeChunk(data []byte, chunk *GridFSChunk) ([]byte, error) {
+	ad, err := chunkAD(chunk)
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.open(data, ad)
+	if err == nil && len(out) > chunk.MaxSize {
+		return nil, errGridFSChunkSize
+	}
+	return out, err
+}
+
+// chunkAD returns the additional data chunk is authenticated with.
+func chunkAD(chunk *GridFSChunk) ([]byte, error) {
+	return bson.Marshal(bson.D{{"files_id", chunk.FilesId}, {"n", chunk.N}})
+}
+
+// seal returns the key id length, the key id, the nonce,
+// and the sealed data, in this order.
+func (c *gfsAESCodec) seal(data, ad []byte) ([]byte, error) {
+	aead, err := c.aead(c.keyId)
+	if err != nil {
+		return nil, err
+	}
+	prefix := 1 + len(c.keyId) + aead.NonceSize()
+	out := make([]byte, prefix, prefix+len(data)+aead.Overhead())
+	out[0] = byte(len(c.keyId))
+	copy(out[1:], c.keyId)
+	nonce := out[1+len(c.keyId):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, data, ad), nil
+}
+
+var errGridFSDecrypt = errors.New("GridFS chunk failed authentication")
+
+func (c *gfsAESCodec) open(data, ad []byte) ([]byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, errGridFSDecrypt
+	}
+	keyId := string(data[1 : 1+data[0]])
+	data = data[1+len(keyId):]
+	aead, err := c.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errGridFSDecrypt
+	}
+	out, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, errGridFSDecrypt
+	}
+	return out, nil
+}
+
+type gfsCodecChain []GridFSCodec
+
+// GridFSCodecChain returns a GridFS codec encoding chunks with each of
+// the provided codecs in order, and decoding them in reverse order.
+func GridFSCodecChain(codecs ...GridFSCodec) GridFSCodec {
+	return gfsCodecChain(codecs)
+}
+
+func (chain gfsCodecChain) Name() string {
+	names := make([]string, len(chain))
+	for i, codec := range chain {
+		names[i] = codec.Name()
+	}
+	return strings.Join(names, "+")
+}
+
+func (chain gfsCodecChain) Encode(data []byte) (out []byte, err error) {
+	out = data
+	for _, codec := range chain {
+		if out, err = codec.Encode(out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (chain gfsCodecChain) Decode(data []byte) (out []byte, err error) {
+	out = data
+	for i := len(chain) - 1; i >= 0; i-- {
+		if out, err = chain[i].Decode(out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (chain gfsCodecChain) EncodeChunk(data []byte, chunk *GridFSChunk) (out []byte, err error) {
+	out = data
+	for _, codec := range chain {
+		if c, ok := codec.(GridFSChunkCodec); ok {
+			out, err = c.EncodeChunk(out, chunk)
+		} else {
+			out, err = codec.Encode(out)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// DecodeChunk bounds the intermediate results by gfsMaxDecodedSize,
+// and only the final one by chunk.MaxSize.
+func (chain gfsCodecChain) DecodeChunk(data []byte, chunk *GridFSChunk) (out []byte, err error) {
+	stage := *chunk
+	stage.MaxSize = gfsMaxDecodedSize
+	out = data
+	for i := len(chain) - 1; i >= 0; i-- {
+		if i == 0 {
+			stage.MaxSize = chunk.MaxSize
+		}
+		if c, ok := chain[i].(GridFSChunkCodec); ok {
+			out, err = c.DecodeChunk(out, &stage)
+		} else {
+			out, err = chain[i].Decode(out)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
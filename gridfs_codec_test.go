@@ -0,0 +1,118 @@
+package mgo_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable"
+)
+
+// CodecS holds the GridFS codec tests that don't need a running server.
+type CodecS struct{}
+
+var _ = Suite(&CodecS{})
+
+var codecKeys = map[string][]byte{
+	"key1": bytes.Repeat([]byte{1}, 32),
+	"key2": bytes.Repeat([]byte{2}, 16),
+}
+
+func codecKey(keyId string) ([]byte, error) {
+	if key, ok := codecKeys[keyId]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown key " + keyId)
+}
+
+func (s *CodecS) TestRoundTrip(c *C) {
+	data := bytes.Repeat([]byte("some compressible data "), 100)
+	codecs := []mgo.GridFSCodec{
+		mgo.NewGridFSGzipCodec(gzip.BestSpeed),
+		mgo.NewGridFSAESCodec("key1", codecKey),
+		mgo.GridFSCodecChain(mgo.NewGridFSGzipCodec(gzip.DefaultCompression), mgo.NewGridFSAESCodec("key2", codecKey)),
+	}
+	names := []string{"gzip", "aes-gcm", "gzip+aes-gcm"}
+	for i, codec := range codecs {
+		c.Assert(codec.Name(), Equals, names[i])
+		encoded, err := codec.Encode(data)
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(encoded, data), Equals, false)
+		decoded, err := codec.Decode(encoded)
+		c.Assert(err, IsNil)
+		c.Assert(decoded, DeepEquals, data)
+	}
+}
+
+func (s *CodecS) TestAESKeyRotation(c *C) {
+	encoded, err := mgo.NewGridFSAESCodec("key1", codecKey).Encode([]byte("data"))
+	c.Assert(err, IsNil)
+
+	decoded, err := mgo.NewGridFSAESCodec("key2", codecKey).Decode(encoded)
+	c.Assert(err, IsNil)
+	c.Assert(string(decoded), Equals, "data")
+
+	_, err = mgo.NewGridFSAESCodec("key3", codecKey).Encode([]byte("data"))
+	c.Assert(err, ErrorMatches, "unknown key key3")
+}
+
+func (s *CodecS) TestAESTampering(c *C) {
+	codec := mgo.NewGridFSAESCodec("key1", codecKey)
+	encoded, err := codec.Encode([]byte("data"))
+	c.Assert(err, IsNil)
+
+	encoded[len(encoded)-1] ^= 1
+	_, err = codec.Decode(encoded)
+	c.Assert(err, ErrorMatches, "GridFS chunk failed authentication")
+
+	for _, data := range [][]byte{nil, {4, 'k'}, {4, 'k', 'e', 'y', '1', 0}} {
+		_, err = codec.Decode(data)
+		c.Assert(err, ErrorMatches, "GridFS chunk failed authentication")
+	}
+}
+
+func (s *CodecS) TestAESChunkBinding(c *C) {
+	codec := mgo.NewGridFSAESCodec("key1", codecKey).(mgo.GridFSChunkCodec)
+	chunk := &mgo.GridFSChunk{FilesId: "myfile", N: 1, MaxSize: 10}
+	encoded, err := codec.EncodeChunk([]byte("data"), chunk)
+	c.Assert(err, IsNil)
+
+	decoded, err := codec.DecodeChunk(encoded, chunk)
+	c.Assert(err, IsNil)
+	c.Assert(string(decoded), Equals, "data")
+
+	others := []*mgo.GridFSChunk{
+		{FilesId: "myfile", N: 2, MaxSize: 10},
+		{FilesId: "otherfile", N: 1, MaxSize: 10},
+		{N: 1, MaxSize: 10},
+	}
+	for _, other := range others {
+		_, err = codec.DecodeChunk(encoded, other)
+		c.Assert(err, ErrorMatches, "GridFS chunk failed authentication")
+	}
+	_, err = codec.Decode(encoded)
+	c.Assert(err, ErrorMatches, "GridFS chunk failed authentication")
+}
+
+func (s *CodecS) TestGzipMaxSize(c *C) {
+	data := make([]byte, 1024*1024)
+	codecs := []mgo.GridFSCodec{
+		mgo.NewGridFSGzipCodec(gzip.BestCompression),
+		mgo.GridFSCodecChain(mgo.NewGridFSGzipCodec(gzip.BestCompression), mgo.NewGridFSAESCodec("key1", codecKey)),
+	}
+	for _, codec := range codecs {
+		chunk := &mgo.GridFSChunk{FilesId: "myfile", MaxSize: len(data)}
+		encoded, err := codec.(mgo.GridFSChunkCodec).EncodeChunk(data, chunk)
+		c.Assert(err, IsNil)
+		c.Assert(len(encoded) < len(data)/100, Equals, true)
+
+		decoded, err := codec.(mgo.GridFSChunkCodec).DecodeChunk(encoded, chunk)
+		c.Assert(err, IsNil)
+		c.Assert(decoded, HasLen, len(data))
+
+		chunk.MaxSize = 1024
+		_, err = codec.(mgo.GridFSChunkCodec).DecodeChunk(encoded, chunk)
+		c.Assert(err, ErrorMatches, "GridFS chunk data exceeds the chunk size")
+	}
+}
@@ -0,0 +1,256 @@
+package mgo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// SetDedup enables or disables the deduplication of the chunks of the
+// files created via gfs from now on. The content of the chunks of these
+// files is stored once for all files in the blobs collection, such as
+// "fs.blobs" for the "fs" prefix, keyed by its SHA-256 digest and by the
+// codec it's encoded with, if any (see SetCodec). The chunks collection
+// then holds references to the content rather than the content itself.
+//
+// Files written with and without deduplication may be mixed, and are
+// read alike. Removing a file releases the content it references, which
+// is removed once no chunk references it anymore. Content left behind by
+// failed writes is removed by CollectGarbage. Deduplicated files may not
+// be read by other drivers or tools.
+//
+// The digests reveal which files share content, even to readers unable
+// to decrypt it, so creating files fails if deduplication is combined
+// with the AES codec.
+//
+// Deduplication works best with content shared in whole chunks, such as
+// the same files uploaded many times. See Stats for how effective it is.
+func (gfs *GridFS) SetDedup(enabled bool) {
+	gfs.dedup = enabled
+}
+
+var errGridFSDedupEncryption = errors.New("GridFS deduplication cannot be combined with encryption")
+
+// checkDedup returns an error if files deduplicated with dedup
+// can't be encoded with codec.
+func checkDedup(dedup bool, codec GridFSCodec) error {
+	if dedup && gfsEncrypts(codec) {
+		return errGridFSDedupEncryption
+	}
+	return nil
+}
+
+// gfsEncrypts returns whether codec encrypts chunks.
+func gfsEncrypts(codec GridFSCodec) bool {
+	switch codec := codec.(type) {
+	case *gfsAESCodec:
+		return true
+	case gfsCodecChain:
+		for _, c := range codec {
+			if gfsEncrypts(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gfsBlob holds the content shared by deduplicated chunks, which
+// reference it by id in their ref field. Gen changes every time
+// the blob is referenced, so that it's only removed once no chunk
+// references it. See releaseBlob.
+type gfsBlob struct {
+	Id   string        "_id"
+	Data []byte        "data,omitempty"
+	Gen  bson.ObjectId "gen,omitempty"
+}
+
+// gfsBlobRef holds the content of a deduplicated chunk being written.
+type gfsBlobRef struct {
+	id   string
+	data []byte
+}
+
+// blobs returns the collection holding the content of the
+// deduplicated chunks stored in chunks.
+func blobs(chunks *Collection) *Collection {
+	return chunks.Database.C(strings.TrimSuffix(chunks.Name, ".chunks") + ".blobs")
+}
+
+// blobId returns the id of the blob holding the chunk content
+// in data, as encoded with the codec of file.
+func (file *GridFile) blobId(data []byte) string {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	if file.doc.Codec != "" {
+		id += ":" + file.doc.Codec
+	}
+	return id
+}
+
+// storeBlobs stores the provided blobs unless they're stored already,
+// and renews their generation. It must be called once the chunks
+// referencing the blobs are inserted, and may be retried safely.
+func storeBlobs(chunks *Collection, refs []gfsBlobRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	err := chunks.EnsureIndex(Index{Key: []string{"ref"}, Sparse: true})
+	if err != nil {
+		return err
+	}
+	c := blobs(chunks)
+	for _, ref := range refs {
+		update := bson.D{
+			{"$set", bson.D{{"gen", bson.NewObjectId()}}},
+			{"$setOnInsert", bson.D{{"data", ref.data}}},
+		}
+		_, err := c.UpsertId(ref.id, update)
+		if IsDup(err) {
+			// Inserted concurrently. Now it's there.
+			_, err = c.UpsertId(ref.id, update)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkData returns the original content of the chunk in doc,
+// fetching it from the blobs of chunks if it's deduplicated.
+func (file *GridFile) chunkData(chunks *Collection, doc *gfsChunk) ([]byte, error) {
+	data := doc.Data
+	if doc.Ref != "" {
+		var blob gfsBlob
+		err := blobs(chunks).FindId(doc.Ref).Select(bson.M{"data": 1}).One(&blob)
+		if err == ErrNotFound {
+			return nil, fmt.Errorf("GridFS chunk %d of file %v references missing data %s", doc.N, file.doc.Id, doc.Ref)
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = blob.Data
+	}
+	return file.decode(data, file.chunkInfo(doc.N, doc.Ref != ""))
+}
+
+// removeChunks removes the chunks matching query, releasing the
+// deduplicated content they reference, and returns how many were
+// removed.
+func (gfs *GridFS) removeChunks(query bson.D) (removed int, err error) {
+	refQuery := append(append(bson.D{}, query...), bson.DocElem{"ref", bson.M{"$exists": true}})
+	var refs []string
+	if err = gfs.Chunks.Find(refQuery).Distinct("ref", &refs); err != nil {
+		return 0, err
+	}
+	info, err := gfs.Chunks.RemoveAll(query)
+	if err != nil {
+		return 0, err
+	}
+	for _, ref := range refs {
+		if _, err = releaseBlob(gfs.Chunks, ref); err != nil {
+			return 0, err
+		}
+	}
+	return info.Removed, nil
+}
+
+// releaseBlob removes the blob with the provided id from the blobs of
+// chunks unless a chunk references it, and reports whether it did.
+//
+// Writers insert chunks before storing the blobs they reference, which
+// renews their generation. A chunk inserted after the lookup below thus
+// either changes the generation before the blob is removed, preventing
+// its removal, or stores the blob again after it's removed.
+func releaseBlob(chunks *Collection, id string) (removed bool, err error) {
+	c := blobs(chunks)
+	var blob gfsBlob
+	err = c.FindId(id).Select(bson.M{"gen": 1}).One(&blob)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := chunks.Find(bson.D{{"ref", id}}).Limit(1).Count()
+	if err != nil || n > 0 {
+		return false, err
+	}
+	err = c.Remove(bson.D{{"_id", id}, {"gen", blob.Gen}})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// collectBlobs removes the blobs of gfs last referenced before limit
+// that no chunk references, and returns how many were removed.
+func (gfs *GridFS) collectBlobs(limit bson.ObjectId) (removed int, err error) {
+	iter := blobs(gfs.Chunks).Find(bson.M{"gen": bson.M{"$lt": limit}}).Select(bson.M{"_id": 1}).Iter()
+	var blob gfsBlob
+	for iter.Next(&blob) {
+		ok, err := releaseBlob(gfs.Chunks, blob.Id)
+		if err != nil {
+			iter.Close()
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+		blob = gfsBlob{}
+	}
+	return removed, iter.Close()
+}
+
+// GridFSStats holds the storage statistics of a GridFS.
+type GridFSStats struct {
+	// Files holds the number of files stored.
+	Files int
+
+	// LogicalBytes holds the sum of the lengths of the files.
+	LogicalBytes int64
+
+	// PhysicalBytes holds the size of the data in the chunks and
+	// blobs collections, which is lower than LogicalBytes when
+	// chunks are deduplicated or compressed.
+	PhysicalBytes int64
+
+	// Blobs holds the number of distinct deduplicated chunks.
+	Blobs int
+}
+
+// Stats returns the storage statistics of gfs, comparing the amount
+// of content stored in files with the amount of data stored for it.
+// See SetDedup and SetCodec.
+func (gfs *GridFS) Stats() (stats *GridFSStats, err error) {
+	var files struct {
+		Files  int   "files"
+		Length int64 "length"
+	}
+	group := bson.M{"$group": bson.M{"_id": nil, "files": bson.M{"$sum": 1}, "length": bson.M{"$sum": "$length"}}}
+	err = gfs.Files.Pipe([]bson.M{group}).One(&files)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	stats = &GridFSStats{Files: files.Files, LogicalBytes: files.Length}
+	for _, c := range []*Collection{gfs.Chunks, blobs(gfs.Chunks)} {
+		var result struct {
+			Size  int64 "size"
+			Count int   "count"
+		}
+		err = c.Database.Run(bson.D{{"collStats", c.Name}}, &result)
+		if err != nil && !isNsNotFound(err) {
+			return nil, err
+		}
+		stats.PhysicalBytes += result.Size
+		if c != gfs.Chunks {
+			stats.Blobs = result.Count
+		}
+	}
+	return stats, nil
+}
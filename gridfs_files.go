@@ -0,0 +1,160 @@
+package mgo
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// GridFSFileInfo holds the details of a file stored in a GridFS, as
+// found in its document in the files collection. See GridFS.FindFiles.
+type GridFSFileInfo struct {
+	Id          interface{} "_id"
+	ChunkSize   int         "chunkSize"
+	UploadDate  time.Time   "uploadDate"
+	Length      int64       ",minsize"
+	MD5         string      ",omitempty"
+	SHA1        string      "sha1,omitempty"
+	SHA256      string      "sha256,omitempty"
+	Filename    string      ",omitempty"
+	ContentType string      "contentType,omitempty"
+	Metadata    *bson.Raw   ",omitempty"
+}
+
+// GetMeta unmarshals the optional "metadata" field of the file into
+// the result parameter. The meaning of keys under that field is
+// user-defined. See GridFile.GetMeta.
+func (info *GridFSFileInfo) GetMeta(result interface{}) error {
+	if info.Metadata == nil {
+		return nil
+	}
+	return bson.Unmarshal(info.Metadata.Data, result)
+}
+
+// ensureFilesIndex creates the index on the files collection used to
+// find the revisions of a file name, unless it's known to exist. It's
+// created when files are written, so that users allowed to read files
+// but not to create indexes may still query them.
+func (gfs *GridFS) ensureFilesIndex() error {
+	return gfs.Files.EnsureIndex(Index{Key: []string{"filename", "uploadDate"}})
+}
+
+// Revisions returns the details of the files with the provided name,
+// from the oldest to the most recent one, which is the one Open returns.
+func (gfs *GridFS) Revisions(name string) (revisions []GridFSFileInfo, err error) {
+	err = gfs.FindFiles(bson.M{"filename": name}).Sort("uploadDate").All(&revisions)
+	return revisions, err
+}
+
+// PruneRevisions removes the files with the provided name except for
+// the keep most recent ones, and returns how many files were removed.
+func (gfs *GridFS) PruneRevisions(name string, keep int) (removed int, err error) {
+	if keep < 0 {
+		return 0, errors.New("negative number of GridFS revisions to keep")
+	}
+	iter := gfs.FindFiles(bson.M{"filename": name}).Sort("-uploadDate").Skip(keep).Iter()
+	var info GridFSFileInfo
+	for iter.Next(&info) {
+		if err = gfs.RemoveId(info.Id); err != nil {
+			iter.Close()
+			return removed, err
+		}
+		removed++
+		info = GridFSFileInfo{}
+	}
+	return removed, iter.Close()
+}
+
+// GridFSFileQuery is a query on the files of a GridFS that delivers
+// their details as GridFSFileInfo values. See GridFS.FindFiles.
+type GridFSFileQuery struct {
+	query *Query
+}
+
+// FindFiles prepares a query on the files of gfs, which may be
+// filtered on their metadata for example.
+//
+// For example, the following snippet lists the files uploaded by a user:
+//
+//     iter := gfs.FindFiles(bson.M{"metadata.owner": user}).Sort("filename").Iter()
+//     var info mgo.GridFSFileInfo
+//     for iter.Next(&info) {
+//         var meta struct{ Owner string }
+//         err := info.GetMeta(&meta)
+//         check(err)
+//         fmt.Println(info.Filename, info.Length, meta.Owner)
+//     }
+//     err := iter.Close()
+//     check(err)
+//
+func (gfs *GridFS) FindFiles(filter interface{}) *GridFSFileQuery {
+	return &GridFSFileQuery{gfs.Files.Find(filter)}
+}
+
+// Sort sets the order the files are delivered in. See Query.Sort.
+func (q *GridFSFileQuery) Sort(fields ...string) *GridFSFileQuery {
+	q.query.Sort(fields...)
+	return q
+}
+
+// Skip skips over the n initial files. See Query.Skip.
+func (q *GridFSFileQuery) Skip(n int) *GridFSFileQuery {
+	q.query.Skip(n)
+	return q
+}
+
+// Limit restricts the maximum number of files delivered to n.
+// See Query.Limit.
+func (q *GridFSFileQuery) Limit(n int) *GridFSFileQuery {
+	q.query.Limit(n)
+	return q
+}
+
+// Count returns the total number of files matching the query.
+func (q *GridFSFileQuery) Count() (n int, err error) {
+	return q.query.Count()
+}
+
+// One retrieves the details of the first file matching the query
+// into info. If no files match, err will be set to ErrNotFound.
+func (q *GridFSFileQuery) One(info *GridFSFileInfo) error {
+	return q.query.One(info)
+}
+
+// All retrieves the details of all the files matching the query
+// into infos.
+func (q *GridFSFileQuery) All(infos *[]GridFSFileInfo) error {
+	return q.query.All(infos)
+}
+
+// Iter executes the query and returns an iterator
+// delivering the details of the matching files.
+func (q *GridFSFileQuery) Iter() *GridFSFileIter {
+	return &GridFSFileIter{q.query.Iter()}
+}
+
+// GridFSFileIter iterates over the details of the files
+// matching a GridFSFileQuery.
+type GridFSFileIter struct {
+	iter *Iter
+}
+
+// Next retrieves the details of the next file into info, returning
+// false when no more files are available or when an error happens.
+// See Iter.Next.
+func (it *GridFSFileIter) Next(info *GridFSFileInfo) bool {
+	return it.iter.Next(info)
+}
+
+// Err returns nil if no errors happened during iteration,
+// or the actual error otherwise.
+func (it *GridFSFileIter) Err() error {
+	return it.iter.Err()
+}
+
+// Close kills the server cursor used by the iterator, if any, and returns
+// nil if no errors happened during iteration, or the actual error otherwise.
+func (it *GridFSFileIter) Close() error {
+	return it.iter.Close()
+}
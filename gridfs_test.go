@@ -27,7 +27,11 @@
 package mgo_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"time"
 
@@ -292,6 +296,85 @@ func (s *S) TestGridFSCreateWithChunking(c *C) {
 	}
 }
 
+func (s *S) TestGridFSWriteSettings(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	gfs := db.GridFS("fs")
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	allSettings := []mgo.GridFSWriteSettings{
+		{},
+		{MaxPendingBytes: 1},
+		{MaxPendingBytes: 50, MaxConcurrency: 2},
+		{BatchChunks: 4, MaxRetries: 2},
+		{BatchChunks: 200, MaxConcurrency: 1},
+	}
+	for _, settings := range allSettings {
+		file, err := gfs.Create("")
+		c.Assert(err, IsNil)
+		file.SetChunkSize(7)
+		file.SetWriteSettings(settings)
+		for i := 0; i < len(data); i += 30 {
+			end := i + 30
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err = file.Write(data[i:end])
+			c.Assert(err, IsNil)
+		}
+		c.Assert(file.Close(), IsNil)
+
+		n, err := db.C("fs.chunks").Find(M{"files_id": file.Id()}).Count()
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, 143, Commentf("settings %#v", settings))
+
+		file, err = gfs.OpenId(file.Id())
+		c.Assert(err, IsNil)
+		b := make([]byte, len(data)+1)
+		n, err = io.ReadFull(file, b)
+		c.Assert(err, Equals, io.ErrUnexpectedEOF)
+		c.Assert(b[:n], DeepEquals, data)
+		c.Assert(file.MD5(), Equals, "cbecbdb0fdd5cec1e242493b6008cc79")
+		c.Assert(file.Close(), IsNil)
+	}
+}
+
+func (s *S) TestGridFSWriteRetryConflict(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	gfs := db.GridFS("fs")
+	err = db.C("fs.chunks").EnsureIndex(mgo.Index{Key: []string{"files_id", "n"}, Unique: true})
+	c.Assert(err, IsNil)
+
+	// A chunk of another writer must not be taken as one inserted
+	// by an earlier attempt.
+	err = db.C("fs.chunks").Insert(M{"files_id": "myid", "n": 1, "data": []byte("xx")})
+	c.Assert(err, IsNil)
+
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	file.SetId("myid")
+	file.SetChunkSize(2)
+	file.SetWriteSettings(mgo.GridFSWriteSettings{MaxRetries: 1, BatchChunks: 2})
+	_, err = file.Write([]byte("abcdef"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(mgo.IsDup(err), Equals, true)
+
+	n, err := gfs.Find(M{"_id": "myid"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
 func (s *S) TestGridFSAbort(c *C) {
 	session, err := mgo.Dial("localhost:40011")
 	c.Assert(err, IsNil)
@@ -471,6 +554,111 @@ func (s *S) TestGridFSReadChunking(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *S) TestGridFSReadAhead(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	gfs := session.DB("mydb").GridFS("fs")
+
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(5)
+	data := []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+	_, err = file.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	for _, window := range []int{0, 1, 3, 10} {
+		file, err = gfs.OpenId(file.Id())
+		c.Assert(err, IsNil)
+		file.SetReadAhead(window)
+
+		b := make([]byte, 3)
+		var result []byte
+		for {
+			n, err := file.Read(b)
+			result = append(result, b[:n]...)
+			if err == io.EOF {
+				break
+			}
+			c.Assert(err, IsNil)
+			if len(result) == 12 {
+				// Seeking backwards discards the window.
+				_, err = file.Seek(2, os.SEEK_SET)
+				c.Assert(err, IsNil)
+				result = append(result[:0], data[:2]...)
+			}
+		}
+		c.Assert(string(result), Equals, string(data), Commentf("window %d", window))
+		c.Assert(file.Close(), IsNil)
+	}
+}
+
+func (s *S) TestGridFSReadAt(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	gfs := session.DB("mydb").GridFS("fs")
+
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(5)
+	data := []byte("abcdefghijklmnopqrstuv")
+	_, err = file.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	file, err = gfs.OpenId(file.Id())
+	c.Assert(err, IsNil)
+	defer file.Close()
+
+	tests := []struct {
+		off, size int64
+		result    string
+		eof       bool
+	}{
+		{0, 3, "abc", false},
+		{3, 4, "defg", false},
+		{5, 5, "fghij", false},
+		{4, 14, "efghijklmnopqr", false},
+		{20, 5, "uv", true},
+		{22, 1, "", true},
+		{0, 22, "abcdefghijklmnopqrstuv", false},
+	}
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for _, t := range tests {
+				b := make([]byte, t.size)
+				n, err := file.ReadAt(b, t.off)
+				c.Check(string(b[:n]), Equals, t.result)
+				c.Check(err == io.EOF, Equals, t.eof)
+				c.Check(err == nil, Equals, !t.eof)
+			}
+			done <- true
+		}()
+	}
+	b := make([]byte, 4)
+	n, err := io.ReadFull(file, b)
+	c.Assert(err, IsNil)
+	c.Assert(string(b[:n]), Equals, "abcd")
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	// A missing chunk is reported.
+	err = session.DB("mydb").C("fs.chunks").Remove(M{"files_id": file.Id(), "n": 2})
+	c.Assert(err, IsNil)
+	n, err = file.ReadAt(make([]byte, 10), 6)
+	c.Assert(n, Equals, 4)
+	c.Assert(err, ErrorMatches, "GridFS chunk 2 of file .* is missing or truncated")
+
+	_, err = file.ReadAt(b, -1)
+	c.Assert(err, ErrorMatches, "negative offset")
+}
+
 func (s *S) TestGridFSOpen(c *C) {
 	session, err := mgo.Dial("localhost:40011")
 	c.Assert(err, IsNil)
@@ -706,3 +894,795 @@ func (s *S) TestGridFSOpenNext(c *C) {
 	c.Assert(iter.Close(), IsNil)
 	c.Assert(f, IsNil)
 }
+
+func (s *S) TestGridFSResumeUpload(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	file, err := gfs.CreateResumable("myid")
+	c.Assert(err, IsNil)
+	file.SetName("myfile.txt")
+	file.SetChunkSize(5)
+	file.SetContentType("text/plain")
+
+	// Write a partial chunk at the end, and leave the file
+	// behind without closing it once the full chunks are in.
+	_, err = file.Write([]byte("abcdefghijklmnopqrstuvw"))
+	c.Assert(err, IsNil)
+	for i := 0; ; i++ {
+		n, err := db.C("fs.chunks").Find(M{"files_id": "myid"}).Count()
+		c.Assert(err, IsNil)
+		if n == 4 {
+			break
+		}
+		c.Assert(i < 50, Equals, true)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	var upload mgo.PendingUpload
+	err = gfs.PendingUploads(nil).One(&upload)
+	c.Assert(err, IsNil)
+	c.Assert(upload.Id, Equals, "myid")
+	c.Assert(upload.Filename, Equals, "myfile.txt")
+	c.Assert(upload.ChunkSize, Equals, 5)
+
+	// Lose a chunk in the middle, to be written again.
+	err = db.C("fs.chunks").Remove(M{"files_id": "myid", "n": 2})
+	c.Assert(err, IsNil)
+
+	file, err = gfs.ResumeUpload("myid")
+	c.Assert(err, IsNil)
+	c.Assert(file.Size(), Equals, int64(10))
+	_, err = file.Write([]byte("klmnopqrstuvwxyz"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	n, err := gfs.PendingUploads(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	file, err = gfs.OpenId("myid")
+	c.Assert(err, IsNil)
+	defer file.Close()
+	c.Assert(file.Name(), Equals, "myfile.txt")
+	c.Assert(file.ContentType(), Equals, "text/plain")
+	c.Assert(file.MD5(), Equals, "c3fcd3d76192e4007dfb496cca67e13b")
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abcdefghijklmnopqrstuvwxyz")
+
+	_, err = gfs.ResumeUpload("myid")
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestGridFSRemoveUpload(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	file, err := gfs.CreateResumable("myid")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(5)
+	_, err = file.Write([]byte("abcdefghij"))
+	c.Assert(err, IsNil)
+	for i := 0; ; i++ {
+		n, err := db.C("fs.chunks").Find(M{"files_id": "myid"}).Count()
+		c.Assert(err, IsNil)
+		if n == 2 {
+			break
+		}
+		c.Assert(i < 50, Equals, true)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err = gfs.RemoveUpload("myid")
+	c.Assert(err, IsNil)
+
+	n, err := db.C("fs.chunks").Find(M{"files_id": "myid"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	n, err = gfs.PendingUploads(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	err = gfs.RemoveUpload("myid")
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	// Aborting a resumable upload drops it as well.
+	file, err = gfs.CreateResumable("otherid")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(2)
+	_, err = file.Write([]byte("abc"))
+	c.Assert(err, IsNil)
+	file.Abort()
+	err = file.Close()
+	c.Assert(err, ErrorMatches, "write aborted")
+
+	n, err = gfs.PendingUploads(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestGridFSVerify(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	file, err := gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(5)
+	_, err = file.Write([]byte("abcdefghijklmnopqrstuvwxyz"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+	id := file.Id()
+
+	report, err := gfs.Verify(id)
+	c.Assert(err, IsNil)
+	c.Assert(report.OK(), Equals, true)
+	c.Assert(report.Chunks, Equals, 6)
+
+	err = db.C("fs.files").UpdateId(id, M{"$set": M{"sha256": "71c480df93d6ae2f1efad1447c66c9525e316218cf51fc8d9ed832f2daf18b73"}})
+	c.Assert(err, IsNil)
+	report, err = gfs.Verify(id)
+	c.Assert(err, IsNil)
+	c.Assert(report.OK(), Equals, true)
+
+	err = db.C("fs.chunks").Update(M{"files_id": id, "n": 1}, M{"$set": M{"data": []byte("FGHIJ")}})
+	c.Assert(err, IsNil)
+	report, err = gfs.Verify(id)
+	c.Assert(err, IsNil)
+	c.Assert(report.Problems, DeepEquals, []string{
+		"content has MD5 3eed6cb45f4a2930c12099ac4c2c334a rather than c3fcd3d76192e4007dfb496cca67e13b",
+		"content has SHA-256 e03420f2601af56e19a54612ccc338e5c88035af3c9c69903330e81c2893aa35 rather than 71c480df93d6ae2f1efad1447c66c9525e316218cf51fc8d9ed832f2daf18b73",
+	})
+
+	err = db.C("fs.chunks").Remove(M{"files_id": id, "n": 2})
+	c.Assert(err, IsNil)
+	err = db.C("fs.chunks").Update(M{"files_id": id, "n": 5}, M{"$set": M{"data": []byte("z!")}})
+	c.Assert(err, IsNil)
+	report, err = gfs.Verify(id)
+	c.Assert(err, IsNil)
+	c.Assert(report.OK(), Equals, false)
+	c.Assert(report.Chunks, Equals, 5)
+	c.Assert(report.MissingChunks, DeepEquals, []int{2})
+	c.Assert(report.Problems, DeepEquals, []string{
+		"chunk 2 is missing",
+		"chunk 5 has 2 bytes rather than 1",
+	})
+
+	_, err = gfs.Verify("missing")
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestGridFSCollectGarbage(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	file, err := gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("some data"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+	id := file.Id()
+
+	file, err = gfs.CreateResumable("pending")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(2)
+	_, err = file.Write([]byte("ab"))
+	c.Assert(err, IsNil)
+
+	past := time.Now().Add(-2 * time.Hour)
+	chunks := db.C("fs.chunks")
+	for i, filesId := range []interface{}{"orphan1", "orphan1", M{"a": 1}, id, "pending"} {
+		err = chunks.Insert(M{"_id": bson.NewObjectIdWithTime(past), "files_id": filesId, "n": 10 + i, "data": []byte("x")})
+		c.Assert(err, IsNil)
+	}
+	err = chunks.Insert(M{"_id": bson.NewObjectId(), "files_id": "recent", "n": 0, "data": []byte("x")})
+	c.Assert(err, IsNil)
+
+	garbage, err := gfs.CollectGarbage(time.Hour)
+	c.Assert(err, IsNil)
+	c.Assert(garbage.Files, Equals, 2)
+	c.Assert(garbage.Chunks, Equals, 3)
+
+	for _, filesId := range []interface{}{"orphan1", M{"a": 1}} {
+		n, err := chunks.Find(M{"files_id": filesId}).Count()
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, 0)
+	}
+	n, err := chunks.Find(M{"files_id": "recent"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	n, err = chunks.Find(M{"files_id": "pending"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	n, err = chunks.Find(M{"files_id": id}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+
+	file.Abort()
+	file.Close()
+}
+
+func (s *S) TestGridFSHash(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("some data"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	h, sum := file.Digest()
+	c.Assert(h, Equals, mgo.GridFSHashMD5)
+	c.Assert(sum, Equals, "1e50210a0202497fb79bc38b6ade6c34")
+	c.Assert(file.MD5(), Equals, sum)
+
+	gfs.SetHash(mgo.GridFSHashSHA256)
+	file, err = gfs.Create("")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("some data"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	result := M{}
+	err = db.C("fs.files").FindId(file.Id()).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["sha256"], Equals, "1307990e6ba5ca145eb35e99182a9bec46531bc54ddf656a602c780fa0240dee")
+	c.Assert(result["md5"], IsNil)
+
+	file, err = gfs.OpenId(file.Id())
+	c.Assert(err, IsNil)
+	h, sum = file.Digest()
+	c.Assert(h, Equals, mgo.GridFSHashSHA256)
+	c.Assert(sum, Equals, "1307990e6ba5ca145eb35e99182a9bec46531bc54ddf656a602c780fa0240dee")
+	c.Assert(file.MD5(), Equals, "")
+	file.Close()
+
+	gfs.SetHash(mgo.GridFSHashNone)
+	file, err = gfs.Create("")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("some data"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	h, sum = file.Digest()
+	c.Assert(h, Equals, mgo.GridFSHashNone)
+	c.Assert(sum, Equals, "")
+
+	err = gfs.SetHash("crc32")
+	c.Assert(err, ErrorMatches, "unknown GridFS hash algorithm: crc32")
+
+	// The previous algorithm is kept.
+	file, err = gfs.Create("")
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+	h, _ = file.Digest()
+	c.Assert(h, Equals, mgo.GridFSHashNone)
+}
+
+func (s *S) TestGridFSResumeUploadHash(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+	gfs.SetHash(mgo.GridFSHashSHA1)
+
+	file, err := gfs.CreateResumable("myid")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(5)
+	_, err = file.Write([]byte("abcdefghijk"))
+	c.Assert(err, IsNil)
+	for i := 0; ; i++ {
+		n, err := db.C("fs.chunks").Find(M{"files_id": "myid"}).Count()
+		c.Assert(err, IsNil)
+		if n == 2 {
+			break
+		}
+		c.Assert(i < 50, Equals, true)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The hash is taken from the upload rather than from gfs.
+	file, err = db.GridFS("fs").ResumeUpload("myid")
+	c.Assert(err, IsNil)
+	c.Assert(file.Size(), Equals, int64(10))
+	_, err = file.Write([]byte("klmnopqrstuvwxyz"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	h, sum := file.Digest()
+	c.Assert(h, Equals, mgo.GridFSHashSHA1)
+	c.Assert(sum, Equals, "32d10c7b8cf96570ca04ce37f2a19d84240d3a89")
+}
+
+func (s *S) TestGridFSCodec(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+	gfs.SetCodec(mgo.GridFSCodecChain(
+		mgo.NewGridFSGzipCodec(gzip.BestCompression),
+		mgo.NewGridFSAESCodec("key1", codecKey),
+	))
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	file, err := gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(1024)
+	_, err = file.Write(content)
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+	id := file.Id()
+
+	result := M{}
+	err = db.C("fs.files").FindId(id).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["codec"], Equals, "gzip+aes-gcm")
+	c.Assert(result["length"], Equals, 10000)
+
+	var chunk struct{ Data []byte }
+	err = db.C("fs.chunks").Find(M{"files_id": id, "n": 0}).One(&chunk)
+	c.Assert(err, IsNil)
+	c.Assert(len(chunk.Data) < 1024, Equals, true)
+
+	file, err = gfs.OpenId(id)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, content)
+
+	_, err = file.Seek(5005, os.SEEK_SET)
+	c.Assert(err, IsNil)
+	b := make([]byte, 10)
+	_, err = io.ReadFull(file, b)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "5678901234")
+
+	n, err := file.ReadAt(b, 2046)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+	c.Assert(string(b), Equals, "6789012345")
+	file.Close()
+
+	report, err := gfs.Verify(id)
+	c.Assert(err, IsNil)
+	c.Assert(report.OK(), Equals, true)
+
+	// Files written without a codec are still read normally.
+	plain := db.GridFS("fs")
+	file, err = plain.Create("plain.txt")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("plain data"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	file, err = gfs.Open("plain.txt")
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "plain data")
+	file.Close()
+
+	// Reading fails without the codec.
+	file, err = plain.OpenId(id)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(file)
+	c.Assert(err, ErrorMatches, `cannot read GridFS file .*: unknown GridFS codec "gzip\+aes-gcm"`)
+	file.Close()
+}
+
+func (s *S) TestGridFSCodecChunkSize(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	codec := mgo.NewGridFSGzipCodec(gzip.BestSpeed)
+	gfs := db.GridFS("fs")
+	gfs.SetCodec(codec)
+
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(5)
+	_, err = file.Write([]byte("abcdefghijkl"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	// A short chunk would shift the content of the following ones.
+	short, err := codec.Encode([]byte("fgh"))
+	c.Assert(err, IsNil)
+	err = db.C("fs.chunks").Update(M{"files_id": file.Id(), "n": 1}, M{"$set": M{"data": short}})
+	c.Assert(err, IsNil)
+
+	file, err = gfs.OpenId(file.Id())
+	c.Assert(err, IsNil)
+	b := make([]byte, 4)
+	_, err = file.ReadAt(b, 6)
+	c.Assert(err, ErrorMatches, "GridFS chunk 1 of file .* decodes to 3 bytes rather than 5")
+	_, err = ioutil.ReadAll(file)
+	c.Assert(err, ErrorMatches, "GridFS chunk 1 of file .* decodes to 3 bytes rather than 5")
+	file.Close()
+}
+
+func (s *S) TestGridFSDedup(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+	gfs.SetDedup(true)
+
+	var ids []interface{}
+	for _, content := range []string{"abcdefghijkl", "abcdefghijkl", "abcde"} {
+		file, err := gfs.Create("")
+		c.Assert(err, IsNil)
+		file.SetChunkSize(5)
+		_, err = file.Write([]byte(content))
+		c.Assert(err, IsNil)
+		err = file.Close()
+		c.Assert(err, IsNil)
+		ids = append(ids, file.Id())
+	}
+
+	// Files written without deduplication are mixed in.
+	file, err := db.GridFS("fs").Create("")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("plain"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	blobs := db.C("fs.blobs")
+	var blob struct {
+		Data []byte
+		Gen  bson.ObjectId
+	}
+	err = blobs.FindId("36bbe50ed96841d10443bcb670d6554f0a34b761be67ec9c4a8ad2c0c44ca42c").One(&blob)
+	c.Assert(err, IsNil)
+	c.Assert(string(blob.Data), Equals, "abcde")
+	c.Assert(blob.Gen.Valid(), Equals, true)
+
+	n, err := db.C("fs.chunks").Find(M{"ref": "36bbe50ed96841d10443bcb670d6554f0a34b761be67ec9c4a8ad2c0c44ca42c"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	n, err = blobs.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	stats, err := gfs.Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Files, Equals, 4)
+	c.Assert(stats.LogicalBytes, Equals, int64(34))
+	c.Assert(stats.Blobs, Equals, 3)
+
+	file, err = gfs.OpenId(ids[1])
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abcdefghijkl")
+	b := make([]byte, 4)
+	_, err = file.ReadAt(b, 4)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "efgh")
+	file.Close()
+
+	report, err := gfs.Verify(ids[1])
+	c.Assert(err, IsNil)
+	c.Assert(report.OK(), Equals, true)
+
+	err = gfs.RemoveId(ids[0])
+	c.Assert(err, IsNil)
+	n, err = blobs.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	err = gfs.RemoveId(ids[1])
+	c.Assert(err, IsNil)
+	n, err = blobs.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	file, err = gfs.OpenId(ids[2])
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abcde")
+	file.Close()
+
+	err = gfs.RemoveId(ids[2])
+	c.Assert(err, IsNil)
+	n, err = blobs.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestGridFSDedupGarbage(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+	gfs.SetDedup(true)
+
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	_, err = file.Write([]byte("abcde"))
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	// Content stored by writes that failed before inserting
+	// the chunks referencing it.
+	past := bson.NewObjectIdWithTime(time.Now().Add(-2 * time.Hour))
+	blobs := db.C("fs.blobs")
+	err = blobs.Insert(M{"_id": "orphan", "data": []byte("x"), "gen": past})
+	c.Assert(err, IsNil)
+	err = blobs.Insert(M{"_id": "recent", "data": []byte("x"), "gen": bson.NewObjectId()})
+	c.Assert(err, IsNil)
+
+	// Content still referenced is kept however old.
+	err = blobs.UpdateId("36bbe50ed96841d10443bcb670d6554f0a34b761be67ec9c4a8ad2c0c44ca42c", M{"$set": M{"gen": past}})
+	c.Assert(err, IsNil)
+
+	garbage, err := gfs.CollectGarbage(time.Hour)
+	c.Assert(err, IsNil)
+	c.Assert(garbage.Blobs, Equals, 1)
+
+	var result []M
+	err = blobs.Find(nil).Select(M{"_id": 1}).Sort("_id").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, []M{{"_id": "36bbe50ed96841d10443bcb670d6554f0a34b761be67ec9c4a8ad2c0c44ca42c"}, {"_id": "recent"}})
+
+	file, err = gfs.OpenId(file.Id())
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abcde")
+	file.Close()
+}
+
+func (s *S) TestGridFSDedupEncryption(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	gfs := session.DB("mydb").GridFS("fs")
+	gfs.SetDedup(true)
+	gfs.SetCodec(mgo.GridFSCodecChain(
+		mgo.NewGridFSGzipCodec(gzip.BestSpeed),
+		mgo.NewGridFSAESCodec("key1", codecKey),
+	))
+
+	_, err = gfs.Create("")
+	c.Assert(err, ErrorMatches, "GridFS deduplication cannot be combined with encryption")
+	_, err = gfs.CreateResumable("myid")
+	c.Assert(err, ErrorMatches, "GridFS deduplication cannot be combined with encryption")
+
+	gfs.SetDedup(false)
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestGridFSDedupCodec(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+	gfs.SetDedup(true)
+	gfs.SetCodec(mgo.NewGridFSGzipCodec(gzip.BestSpeed))
+
+	for i := 0; i < 2; i++ {
+		file, err := gfs.Create("myfile.txt")
+		c.Assert(err, IsNil)
+		_, err = file.Write([]byte("abcde"))
+		c.Assert(err, IsNil)
+		err = file.Close()
+		c.Assert(err, IsNil)
+	}
+
+	var ids []string
+	err = db.C("fs.blobs").Find(nil).Distinct("_id", &ids)
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, []string{"36bbe50ed96841d10443bcb670d6554f0a34b761be67ec9c4a8ad2c0c44ca42c:gzip"})
+
+	file, err := gfs.Open("myfile.txt")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abcde")
+	file.Close()
+}
+
+func (s *S) TestGridFSRevisions(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	for i := 0; i < 4; i++ {
+		file, err := gfs.Create("myfile.txt")
+		c.Assert(err, IsNil)
+		file.SetUploadDate(time.Date(2020, 1, i+1, 0, 0, 0, 0, time.UTC))
+		_, err = file.Write([]byte{'0' + byte(i)})
+		c.Assert(err, IsNil)
+		err = file.Close()
+		c.Assert(err, IsNil)
+	}
+
+	indexes, err := db.C("fs.files").Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes, HasLen, 2)
+	c.Assert(indexes[1].Key, DeepEquals, []string{"filename", "uploadDate"})
+
+	revisions, err := gfs.Revisions("myfile.txt")
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 4)
+	for i, info := range revisions {
+		c.Assert(info.Filename, Equals, "myfile.txt")
+		c.Assert(info.Length, Equals, int64(1))
+		c.Assert(info.UploadDate.Day(), Equals, i+1)
+	}
+
+	removed, err := gfs.PruneRevisions("myfile.txt", 2)
+	c.Assert(err, IsNil)
+	c.Assert(removed, Equals, 2)
+
+	revisions, err = gfs.Revisions("myfile.txt")
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 2)
+	c.Assert(revisions[0].UploadDate.Day(), Equals, 3)
+	c.Assert(revisions[1].UploadDate.Day(), Equals, 4)
+
+	n, err := db.C("fs.chunks").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+
+	file, err := gfs.OpenId(revisions[0].Id)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "2")
+	file.Close()
+
+	_, err = gfs.PruneRevisions("myfile.txt", -1)
+	c.Assert(err, ErrorMatches, "negative number of GridFS revisions to keep")
+
+	removed, err = gfs.PruneRevisions("myfile.txt", 0)
+	c.Assert(err, IsNil)
+	c.Assert(removed, Equals, 2)
+	revisions, err = gfs.Revisions("myfile.txt")
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 0)
+}
+
+func (s *S) TestGridFSFindFiles(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	type meta struct {
+		Owner string
+		Size  int
+	}
+	for i, owner := range []string{"alice", "bob", "alice"} {
+		file, err := gfs.Create(fmt.Sprintf("file%d.txt", i))
+		c.Assert(err, IsNil)
+		file.SetMeta(meta{owner, i})
+		_, err = file.Write([]byte("data"))
+		c.Assert(err, IsNil)
+		err = file.Close()
+		c.Assert(err, IsNil)
+	}
+
+	iter := gfs.FindFiles(M{"metadata.owner": "alice"}).Sort("-filename").Iter()
+	var info mgo.GridFSFileInfo
+	var names []string
+	for iter.Next(&info) {
+		var m meta
+		err = info.GetMeta(&m)
+		c.Assert(err, IsNil)
+		c.Assert(m.Owner, Equals, "alice")
+		c.Assert(info.Length, Equals, int64(4))
+		c.Assert(info.MD5, Equals, "8d777f385d3dfec8815d20f7496026dc")
+		names = append(names, fmt.Sprintf("%s:%d", info.Filename, m.Size))
+	}
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(names, DeepEquals, []string{"file2.txt:2", "file0.txt:0"})
+
+	n, err := gfs.FindFiles(M{"metadata.size": M{"$gte": 1}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+
+	err = gfs.FindFiles(M{"filename": "file1.txt"}).One(&info)
+	c.Assert(err, IsNil)
+	c.Assert(info.Filename, Equals, "file1.txt")
+
+	err = gfs.FindFiles(M{"filename": "missing.txt"}).One(&info)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestGridFSFindFilesReadOnly(c *C) {
+	session, err := mgo.Dial("localhost:40002")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	admindb := session.DB("admin")
+	err = admindb.Login("root", "rapadura")
+	c.Assert(err, IsNil)
+
+	mydb := session.DB("mydb")
+	err = mydb.C("fs.files").Insert(M{"_id": 1, "filename": "myfile.txt", "length": 0, "chunkSize": 255 * 1024})
+	c.Assert(err, IsNil)
+	err = mydb.UpsertUser(&mgo.User{Username: "myruser", Password: "mypass", Roles: []mgo.Role{mgo.RoleRead}})
+	c.Assert(err, IsNil)
+	err = mydb.Login("myruser", "mypass")
+	c.Assert(err, IsNil)
+	admindb.Logout()
+
+	// Querying doesn't need the files index, which a
+	// read-only user can't create.
+	revisions, err := mydb.GridFS("fs").Revisions("myfile.txt")
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 1)
+	c.Assert(revisions[0].Id, Equals, 1)
+}
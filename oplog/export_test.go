@@ -0,0 +1,22 @@
+package oplog
+
+import (
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// Deliver runs the provided entries through a Tailer with opts, as if
+// read from the oplog, and returns the entries delivered and Last.
+func Deliver(opts Options, raws []*Entry) (entries []Entry, last bson.MongoTimestamp, err error) {
+	t := &Tailer{opts: opts}
+	for _, raw := range raws {
+		if err = t.queue(raw); err != nil {
+			return nil, 0, err
+		}
+		if len(t.pending) > 0 {
+			entries = append(entries, t.pending...)
+			t.pending = nil
+			t.last = t.pendTs
+		}
+	}
+	return entries, t.Last(), nil
+}
@@ -0,0 +1,457 @@
+// The oplog package implements typed access to the replication log of
+// MongoDB replica sets, for following the changes made to the data.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/replica-set-oplog/
+//
+package oplog
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// Operation types of oplog entries.
+const (
+	OpInsert  = "i"
+	OpUpdate  = "u"
+	OpDelete  = "d"
+	OpCommand = "c"
+	OpNoop    = "n"
+)
+
+// ErrHistoryLost is returned by Tailer when the oplog no longer holds the
+// entries following the timestamp the tailing was asked to resume from.
+var ErrHistoryLost = errors.New("oplog no longer holds the entries to resume from")
+
+// SessionId identifies the logical session an operation ran in.
+type SessionId struct {
+	Id  bson.Binary `bson:"id"`
+	UID bson.Binary `bson:"uid,omitempty"`
+}
+
+// Entry holds an operation recorded in the oplog.
+type Entry struct {
+	// Timestamp uniquely identifies the entry and orders it
+	// relative to the other entries in the oplog.
+	Timestamp bson.MongoTimestamp `bson:"ts"`
+
+	// Op is one of the OpInsert, OpUpdate, OpDelete, OpCommand
+	// and OpNoop constants.
+	Op string `bson:"op"`
+
+	// Namespace holds the full name of the collection affected, such
+	// as "mydb.mycoll", or "mydb.$cmd" for commands.
+	Namespace string `bson:"ns"`
+
+	// Object holds the document inserted, the update applied, the
+	// selector of the document deleted, or the command run.
+	Object bson.Raw `bson:"o"`
+
+	// Object2 holds the selector of the document updated.
+	Object2 *bson.Raw `bson:"o2,omitempty"`
+
+	// CollectionUUID identifies the collection affected, on MongoDB 3.6+.
+	CollectionUUID *bson.Binary `bson:"ui,omitempty"`
+
+	// SessionId and TxnNumber identify the session and the transaction
+	// the operation was part of, if any, on MongoDB 3.6+.
+	SessionId *SessionId `bson:"lsid,omitempty"`
+	TxnNumber int64      `bson:"txnNumber,omitempty"`
+
+	// Wall holds the wall clock time the entry was recorded at,
+	// on MongoDB 3.6+.
+	Wall time.Time `bson:"wall,omitempty"`
+}
+
+// Expand returns the individual operations applied by an applyOps
+// command entry, such as those recording a transaction, with the
+// timestamp, session and transaction of the entry itself. Entries
+// that are not applyOps commands are returned unchanged.
+//
+// Transactions that are recorded over several entries on MongoDB 4.2+
+// are expanded one entry at a time, including entries of transactions
+// that are later aborted. Tailer only delivers the operations of
+// transactions once they are committed.
+func (e *Entry) Expand() ([]Entry, error) {
+	if e.Op != OpCommand || e.Object.Kind == 0 {
+		return []Entry{*e}, nil
+	}
+	var cmd struct {
+		ApplyOps []Entry `bson:"applyOps"`
+	}
+	if err := e.Object.Unmarshal(&cmd); err != nil {
+		return nil, err
+	}
+	if cmd.ApplyOps == nil {
+		return []Entry{*e}, nil
+	}
+	var entries []Entry
+	for i := range cmd.ApplyOps {
+		op := &cmd.ApplyOps[i]
+		op.Timestamp = e.Timestamp
+		op.SessionId = e.SessionId
+		op.TxnNumber = e.TxnNumber
+		op.Wall = e.Wall
+		expanded, err := op.Expand()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, expanded...)
+	}
+	return entries, nil
+}
+
+// Options holds the settings of a Tailer.
+type Options struct {
+	// Namespaces restricts the entries delivered to those affecting
+	// the provided collections, such as "mydb.mycoll". A "mydb.*"
+	// namespace matches all collections in the mydb database.
+	Namespaces []string
+
+	// Ops restricts the entries delivered to the provided
+	// operation types. See the Op constants.
+	Ops []string
+
+	// Timeout is how long Tailer.Next waits for new entries before
+	// returning false, with Tailer.Timeout reporting true. If zero,
+	// Next waits indefinitely.
+	Timeout time.Duration
+}
+
+// tailTimeout is how long the underlying cursor waits for new entries
+// before its state is checked, when Options.Timeout is zero.
+var tailTimeout = 5 * time.Second
+
+// maxRetries is how many times in a row the Tailer restarts its
+// query after an error before giving up.
+const maxRetries = 5
+
+// Tailer follows the entries added to the oplog of a replica set. If
+// the cursor used is lost, due to a failover for example, the Tailer
+// refreshes its session and resumes from the last entry delivered.
+//
+// The operations of transactions recorded over several entries, or
+// prepared before being committed, on MongoDB 4.2+, are held until the
+// transaction commits, and dropped if it aborts. The entries recording
+// the commit or abort are not delivered themselves.
+//
+// A Tailer is not safe for concurrent use.
+type Tailer struct {
+	session *mgo.Session
+	opts    Options
+	last    bson.MongoTimestamp
+	iter    *mgo.Iter
+	pending []Entry
+	pendTs  bson.MongoTimestamp
+	txns    map[string]*openTxn
+	retries int
+	restart bool
+	timeout bool
+	err     error
+}
+
+// openTxn holds the operations of a transaction that isn't
+// committed yet, and the timestamp of its first entry.
+type openTxn struct {
+	first   bson.MongoTimestamp
+	entries []Entry
+}
+
+// txnCmd holds the fields of command entries that relate
+// to transactions.
+type txnCmd struct {
+	ApplyOps          []bson.Raw  `bson:"applyOps"`
+	PartialTxn        bool        `bson:"partialTxn"`
+	Prepare           bool        `bson:"prepare"`
+	CommitTransaction interface{} `bson:"commitTransaction"`
+	AbortTransaction  interface{} `bson:"abortTransaction"`
+}
+
+// txnKey returns the key identifying the transaction e is part of,
+// or false if it's not part of one.
+func txnKey(e *Entry) (string, bool) {
+	if e.SessionId == nil || e.TxnNumber == 0 {
+		return "", false
+	}
+	key := string(e.SessionId.Id.Data) + "/" + string(e.SessionId.UID.Data) + "/" + strconv.FormatInt(e.TxnNumber, 10)
+	return key, true
+}
+
+// NewTailer returns a Tailer delivering the oplog entries following
+// the one with the from timestamp, or the entries added from now on
+// if from is zero. The Tailer uses a copy of session, which must be
+// connected to a replica set.
+//
+// For example:
+//
+//     tailer := oplog.NewTailer(session, lastTs, oplog.Options{
+//         Namespaces: []string{"mydb.mycoll"},
+//         Ops:        []string{oplog.OpInsert, oplog.OpUpdate},
+//     })
+//     var entry oplog.Entry
+//     for tailer.Next(&entry) {
+//         fmt.Println(entry.Op, entry.Namespace)
+//         lastTs = tailer.Last()
+//     }
+//     err := tailer.Close()
+//
+func NewTailer(session *mgo.Session, from bson.MongoTimestamp, opts Options) *Tailer {
+	t := &Tailer{
+		session: session.Copy(),
+		opts:    opts,
+		last:    from,
+	}
+	if from == 0 {
+		var last Entry
+		err := t.oplog().Find(nil).Sort("-$natural").One(&last)
+		if err != nil && err != mgo.ErrNotFound {
+			t.err = err
+		}
+		t.last = last.Timestamp
+	} else {
+		t.err = t.checkHistory()
+	}
+	return t
+}
+
+// checkHistory returns ErrHistoryLost if the oplog doesn't hold the
+// entries following the last one processed anymore.
+func (t *Tailer) checkHistory() error {
+	var first Entry
+	err := t.oplog().Find(nil).Sort("$natural").One(&first)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if first.Timestamp > t.last {
+		return ErrHistoryLost
+	}
+	return nil
+}
+
+func (t *Tailer) oplog() *mgo.Collection {
+	return t.session.DB("local").C("oplog.rs")
+}
+
+// Last returns the timestamp of the last oplog entry the Tailer fully
+// processed, including entries that were filtered out. It may be
+// provided to NewTailer to resume after that entry.
+//
+// While transactions are held uncommitted, Last precedes the first
+// entry of the oldest of them, so that resuming doesn't lose their
+// operations. Entries following it that were delivered already are
+// then delivered again.
+func (t *Tailer) Last() bson.MongoTimestamp {
+	last := t.last
+	for _, txn := range t.txns {
+		if txn.first-1 < last {
+			last = txn.first - 1
+		}
+	}
+	return last
+}
+
+// Next retrieves the next oplog entry matching the Tailer options into
+// entry, returning false when the configured timeout elapses or when
+// the tailing fails. After Next returns false, Err reports whether
+// tailing failed and Timeout whether Next may be called again.
+func (t *Tailer) Next(entry *Entry) bool {
+	t.timeout = false
+	for t.err == nil {
+		if len(t.pending) > 0 {
+			*entry = t.pending[0]
+			t.pending = t.pending[1:]
+			if len(t.pending) == 0 {
+				t.last = t.pendTs
+			}
+			return true
+		}
+		if t.iter == nil {
+			if t.restart {
+				// Entries may have rolled off the oplog while
+				// the cursor was gone.
+				if err := t.checkHistory(); err != nil {
+					if err == ErrHistoryLost || !t.retry(err) {
+						t.err = err
+					}
+					continue
+				}
+				t.restart = false
+			}
+			timeout := t.opts.Timeout
+			if timeout <= 0 {
+				timeout = tailTimeout
+			}
+			query := t.oplog().Find(bson.M{"ts": bson.M{"$gt": t.last}})
+			t.iter = query.Sort("$natural").LogReplay().Tail(timeout)
+		}
+		var raw Entry
+		if t.iter.Next(&raw) {
+			t.retries = 0
+			t.err = t.queue(&raw)
+			continue
+		}
+		if t.iter.Timeout() {
+			if t.opts.Timeout > 0 {
+				t.timeout = true
+				return false
+			}
+			continue
+		}
+
+		// The cursor is gone. Restart the query from the last
+		// entry, on a new socket if the old one is broken.
+		err := t.iter.Close()
+		t.iter = nil
+		t.restart = t.last != 0
+		if err == nil {
+			time.Sleep(100 * time.Millisecond)
+		} else if !t.retry(err) {
+			t.err = err
+		}
+	}
+	return false
+}
+
+// retry prepares to retry after err, on a new socket, unless too
+// many attempts failed already.
+func (t *Tailer) retry(err error) bool {
+	if t.retries++; t.retries > maxRetries {
+		return false
+	}
+	t.session.Refresh()
+	time.Sleep(time.Duration(t.retries) * 100 * time.Millisecond)
+	return true
+}
+
+// queue expands raw and queues the resulting entries that match the
+// Tailer options for delivery, holding those of transactions until
+// they commit.
+func (t *Tailer) queue(raw *Entry) error {
+	entries, err := t.expandTxn(raw)
+	if err != nil {
+		return err
+	}
+	t.pendTs = raw.Timestamp
+	for _, entry := range entries {
+		if t.matches(&entry) {
+			t.pending = append(t.pending, entry)
+		}
+	}
+	if len(t.pending) == 0 {
+		t.last = raw.Timestamp
+	}
+	return nil
+}
+
+// expandTxn returns the entries to be delivered for raw. These are
+// the operations raw expands to, unless raw is part of a transaction,
+// in which case all of the operations of the transaction are returned
+// once it commits, and none otherwise.
+func (t *Tailer) expandTxn(raw *Entry) ([]Entry, error) {
+	key, ok := txnKey(raw)
+	if !ok || raw.Op != OpCommand || raw.Object.Kind == 0 {
+		return raw.Expand()
+	}
+	var cmd txnCmd
+	if err := raw.Object.Unmarshal(&cmd); err != nil {
+		return nil, err
+	}
+	txn := t.txns[key]
+	switch {
+	case cmd.ApplyOps != nil:
+		entries, err := raw.Expand()
+		if err != nil {
+			return nil, err
+		}
+		if !cmd.PartialTxn && !cmd.Prepare {
+			// The last entry of a transaction that wasn't prepared.
+			if txn == nil {
+				return entries, nil
+			}
+			delete(t.txns, key)
+			return append(txn.entries, entries...), nil
+		}
+		if txn == nil {
+			if t.txns == nil {
+				t.txns = make(map[string]*openTxn)
+			}
+			txn = &openTxn{first: raw.Timestamp}
+			t.txns[key] = txn
+		}
+		txn.entries = append(txn.entries, entries...)
+		return nil, nil
+	case cmd.CommitTransaction != nil:
+		delete(t.txns, key)
+		if txn == nil {
+			return nil, nil
+		}
+		return txn.entries, nil
+	case cmd.AbortTransaction != nil:
+		delete(t.txns, key)
+		return nil, nil
+	}
+	return raw.Expand()
+}
+
+func (t *Tailer) matches(entry *Entry) bool {
+	if len(t.opts.Ops) > 0 {
+		found := false
+		for _, op := range t.opts.Ops {
+			if op == entry.Op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(t.opts.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range t.opts.Namespaces {
+		if ns == entry.Namespace {
+			return true
+		}
+		if strings.HasSuffix(ns, ".*") && strings.HasPrefix(entry.Namespace, ns[:len(ns)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeout returns true if Next returned false due to the timeout
+// set in Options elapsing with no new entries.
+func (t *Tailer) Timeout() bool {
+	return t.timeout
+}
+
+// Err returns nil if no errors happened while tailing, or
+// the actual error otherwise.
+func (t *Tailer) Err() error {
+	return t.err
+}
+
+// Close stops tailing and releases the resources used by the Tailer,
+// returning nil if no errors happened while tailing, or the actual
+// error otherwise.
+func (t *Tailer) Close() error {
+	if t.iter != nil {
+		if err := t.iter.Close(); err != nil && t.err == nil {
+			t.err = err
+		}
+		t.iter = nil
+	}
+	if t.session != nil {
+		t.session.Close()
+		t.session = nil
+	}
+	return t.err
+}
@@ -0,0 +1,261 @@
+package oplog_test
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2-unstable"
+	"gopkg.in/mgo.v2-unstable/bson"
+	"gopkg.in/mgo.v2-unstable/oplog"
+)
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+type M map[string]interface{}
+
+// ExpandS holds the tests that don't need a running server.
+type ExpandS struct{}
+
+var _ = Suite(&ExpandS{})
+
+func unmarshalEntry(c *C, doc interface{}) *oplog.Entry {
+	data, err := bson.Marshal(doc)
+	c.Assert(err, IsNil)
+	var entry oplog.Entry
+	err = bson.Unmarshal(data, &entry)
+	c.Assert(err, IsNil)
+	return &entry
+}
+
+func (s *ExpandS) TestExpandPlain(c *C) {
+	entry := unmarshalEntry(c, bson.D{
+		{"ts", bson.MongoTimestamp(42)},
+		{"op", "u"},
+		{"ns", "mydb.mycoll"},
+		{"o", M{"$set": M{"a": 1}}},
+		{"o2", M{"_id": 1}},
+	})
+	entries, err := entry.Expand()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Op, Equals, oplog.OpUpdate)
+	c.Assert(entries[0].Namespace, Equals, "mydb.mycoll")
+
+	var selector struct {
+		Id int `bson:"_id"`
+	}
+	c.Assert(entries[0].Object2, NotNil)
+	err = entries[0].Object2.Unmarshal(&selector)
+	c.Assert(err, IsNil)
+	c.Assert(selector.Id, Equals, 1)
+}
+
+func (s *ExpandS) TestExpandApplyOps(c *C) {
+	lsid := M{"id": bson.Binary{Kind: 4, Data: []byte("0123456789abcdef")}}
+	entry := unmarshalEntry(c, bson.D{
+		{"ts", bson.MongoTimestamp(42)},
+		{"op", "c"},
+		{"ns", "admin.$cmd"},
+		{"lsid", lsid},
+		{"txnNumber", int64(7)},
+		{"o", bson.D{{"applyOps", []bson.D{
+			{{"op", "i"}, {"ns", "mydb.a"}, {"o", M{"_id": 1}}},
+			{{"op", "d"}, {"ns", "mydb.b"}, {"o", M{"_id": 2}}},
+		}}}},
+	})
+	entries, err := entry.Expand()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Op, Equals, oplog.OpInsert)
+	c.Assert(entries[0].Namespace, Equals, "mydb.a")
+	c.Assert(entries[1].Op, Equals, oplog.OpDelete)
+	c.Assert(entries[1].Namespace, Equals, "mydb.b")
+	for _, e := range entries {
+		c.Assert(e.Timestamp, Equals, bson.MongoTimestamp(42))
+		c.Assert(e.TxnNumber, Equals, int64(7))
+		c.Assert(e.SessionId, NotNil)
+		c.Assert(e.SessionId.Id.Data, DeepEquals, []byte("0123456789abcdef"))
+	}
+}
+
+func (s *ExpandS) TestExpandOtherCommand(c *C) {
+	entry := unmarshalEntry(c, bson.D{
+		{"ts", bson.MongoTimestamp(42)},
+		{"op", "c"},
+		{"ns", "mydb.$cmd"},
+		{"o", bson.D{{"create", "mycoll"}}},
+	})
+	entries, err := entry.Expand()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Namespace, Equals, "mydb.$cmd")
+}
+
+func txnEntry(c *C, ts int64, txnNumber int64, o bson.D) *oplog.Entry {
+	return unmarshalEntry(c, bson.D{
+		{"ts", bson.MongoTimestamp(ts)},
+		{"op", "c"},
+		{"ns", "admin.$cmd"},
+		{"lsid", M{"id": bson.Binary{Kind: 4, Data: []byte("0123456789abcdef")}}},
+		{"txnNumber", txnNumber},
+		{"o", o},
+	})
+}
+
+func insertOps(ids ...int) []bson.D {
+	var ops []bson.D
+	for _, id := range ids {
+		ops = append(ops, bson.D{{"op", "i"}, {"ns", "mydb.mycoll"}, {"o", M{"_id": id}}})
+	}
+	return ops
+}
+
+func (s *ExpandS) TestTailerTransactions(c *C) {
+	raws := []*oplog.Entry{
+		// A transaction recorded over several entries.
+		txnEntry(c, 10, 1, bson.D{{"applyOps", insertOps(1, 2)}, {"partialTxn", true}}),
+		// A plain insert in between.
+		unmarshalEntry(c, bson.D{{"ts", bson.MongoTimestamp(11)}, {"op", "i"}, {"ns", "mydb.mycoll"}, {"o", M{"_id": 100}}}),
+		// A prepared transaction that aborts.
+		txnEntry(c, 12, 2, bson.D{{"applyOps", insertOps(3)}, {"prepare", true}}),
+		txnEntry(c, 13, 1, bson.D{{"applyOps", insertOps(4)}, {"count", 3}}),
+		txnEntry(c, 14, 2, bson.D{{"abortTransaction", 1}}),
+		// A prepared transaction that commits.
+		txnEntry(c, 15, 3, bson.D{{"applyOps", insertOps(5)}, {"prepare", true}}),
+		txnEntry(c, 16, 3, bson.D{{"commitTransaction", 1}, {"commitTimestamp", bson.MongoTimestamp(16)}}),
+		// A transaction recorded in a single entry.
+		txnEntry(c, 17, 4, bson.D{{"applyOps", insertOps(6)}}),
+	}
+	entries, last, err := oplog.Deliver(oplog.Options{}, raws)
+	c.Assert(err, IsNil)
+	c.Assert(last, Equals, bson.MongoTimestamp(17))
+
+	var ids []int
+	for _, entry := range entries {
+		var doc struct {
+			Id int `bson:"_id"`
+		}
+		c.Assert(entry.Op, Equals, oplog.OpInsert)
+		c.Assert(entry.Object.Unmarshal(&doc), IsNil)
+		ids = append(ids, doc.Id)
+	}
+	c.Assert(ids, DeepEquals, []int{100, 1, 2, 4, 5, 6})
+}
+
+func (s *ExpandS) TestTailerTransactionLast(c *C) {
+	raws := []*oplog.Entry{
+		unmarshalEntry(c, bson.D{{"ts", bson.MongoTimestamp(10)}, {"op", "n"}, {"ns", ""}, {"o", M{}}}),
+		txnEntry(c, 11, 1, bson.D{{"applyOps", insertOps(1)}, {"partialTxn", true}}),
+		unmarshalEntry(c, bson.D{{"ts", bson.MongoTimestamp(12)}, {"op", "i"}, {"ns", "mydb.mycoll"}, {"o", M{"_id": 100}}}),
+	}
+	entries, last, err := oplog.Deliver(oplog.Options{}, raws)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+
+	// Resuming must not skip the open transaction.
+	c.Assert(last, Equals, bson.MongoTimestamp(10))
+}
+
+// S holds the tests that run against the rs1 replica set
+// started by the test harness.
+type S struct {
+	session *mgo.Session
+}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpTest(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	s.session = session
+}
+
+func (s *S) TearDownTest(c *C) {
+	err := s.session.DB("oplogtest").DropDatabase()
+	c.Assert(err, IsNil)
+	s.session.Close()
+}
+
+func (s *S) TestTailer(c *C) {
+	coll := s.session.DB("oplogtest").C("mycoll")
+	err := coll.Insert(M{"_id": 0})
+	c.Assert(err, IsNil)
+
+	tailer := oplog.NewTailer(s.session, 0, oplog.Options{
+		Namespaces: []string{"oplogtest.mycoll"},
+		Timeout:    2 * time.Second,
+	})
+	defer tailer.Close()
+
+	err = s.session.DB("oplogtest").C("other").Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"_id": 2})
+	c.Assert(err, IsNil)
+	err = coll.UpdateId(2, M{"$set": M{"a": 1}})
+	c.Assert(err, IsNil)
+	err = coll.RemoveId(2)
+	c.Assert(err, IsNil)
+
+	var entry oplog.Entry
+	var ops []string
+	for len(ops) < 3 && tailer.Next(&entry) {
+		c.Assert(entry.Namespace, Equals, "oplogtest.mycoll")
+		ops = append(ops, entry.Op)
+	}
+	c.Assert(tailer.Err(), IsNil)
+	c.Assert(ops, DeepEquals, []string{oplog.OpInsert, oplog.OpUpdate, oplog.OpDelete})
+
+	c.Assert(tailer.Next(&entry), Equals, false)
+	c.Assert(tailer.Timeout(), Equals, true)
+	c.Assert(tailer.Err(), IsNil)
+}
+
+func (s *S) TestTailerResume(c *C) {
+	coll := s.session.DB("oplogtest").C("mycoll")
+
+	tailer := oplog.NewTailer(s.session, 0, oplog.Options{
+		Namespaces: []string{"oplogtest.*"},
+		Ops:        []string{oplog.OpInsert},
+		Timeout:    2 * time.Second,
+	})
+	for i := 0; i < 3; i++ {
+		err := coll.Insert(M{"_id": i})
+		c.Assert(err, IsNil)
+	}
+
+	var entry oplog.Entry
+	c.Assert(tailer.Next(&entry), Equals, true)
+	last := tailer.Last()
+	c.Assert(last, Equals, entry.Timestamp)
+	c.Assert(tailer.Close(), IsNil)
+
+	tailer = oplog.NewTailer(s.session, last, oplog.Options{
+		Namespaces: []string{"oplogtest.*"},
+		Ops:        []string{oplog.OpInsert},
+		Timeout:    2 * time.Second,
+	})
+	defer tailer.Close()
+
+	var ids []int
+	for len(ids) < 2 && tailer.Next(&entry) {
+		var doc struct {
+			Id int `bson:"_id"`
+		}
+		err := entry.Object.Unmarshal(&doc)
+		c.Assert(err, IsNil)
+		ids = append(ids, doc.Id)
+	}
+	c.Assert(tailer.Err(), IsNil)
+	c.Assert(ids, DeepEquals, []int{1, 2})
+}
+
+func (s *S) TestTailerHistoryLost(c *C) {
+	tailer := oplog.NewTailer(s.session, bson.MongoTimestamp(1), oplog.Options{})
+	var entry oplog.Entry
+	c.Assert(tailer.Next(&entry), Equals, false)
+	c.Assert(tailer.Err(), Equals, oplog.ErrHistoryLost)
+	c.Assert(tailer.Close(), Equals, oplog.ErrHistoryLost)
+}
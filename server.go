@@ -72,8 +72,19 @@ type mongoServerInfo struct {
 	Tags           bson.D
 	MaxWireVersion int
 	SetName        string
+
+	MaxBsonObjectSize   int
+	MaxMessageSizeBytes int
+	MaxWriteBatchSize   int
 }
 
+// Limits assumed when the server does not report them in isMaster.
+const (
+	defaultMaxBsonObjectSize   = 16 * 1024 * 1024
+	defaultMaxMessageSizeBytes = 48000000
+	defaultMaxWriteBatchSize   = 1000
+)
+
 var defaultServerInfo mongoServerInfo
 
 func newServer(addr string, tcpaddr *net.TCPAddr, sync chan bool, dial dialer) *mongoServer {
@@ -91,6 +91,7 @@ type Session struct {
 	creds            []Credential
 	poolLimit        int
 	bypassValidation bool
+	autoEncryption   *autoEncrypter
 }
 
 type Database struct {
@@ -144,6 +145,7 @@ type Iter struct {
 var (
 	ErrNotFound = errors.New("not found")
 	ErrCursor   = errors.New("invalid cursor")
+	ErrNoMongos = errors.New("no mongos available")
 )
 
 const (
@@ -809,6 +811,28 @@ type User struct {
 	// WARNING: This setting was only ever supported in MongoDB 2.4,
 	// and is now obsolete.
 	UserSource string `bson:"userSource,omitempty"`
+
+	// AuthenticationRestrictions limits the client and server addresses
+	// the user may authenticate from and to. Requires MongoDB 3.6+.
+	AuthenticationRestrictions []AuthenticationRestriction `bson:"authenticationRestrictions,omitempty"`
+
+	// Mechanisms restricts the SCRAM mechanisms the user's credentials
+	// are created for, such as "SCRAM-SHA-1" or "SCRAM-SHA-256".
+	// Requires MongoDB 4.0+.
+	Mechanisms []string `bson:"mechanisms,omitempty"`
+}
+
+// AuthenticationRestriction limits the addresses a user or role may
+// authenticate from and to. Addresses are provided as IP addresses
+// or CIDR ranges, and an empty list imposes no restriction.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/method/db.createUser/#authentication-restrictions
+//
+type AuthenticationRestriction struct {
+	ClientSource  []string `bson:"clientSource,omitempty"`
+	ServerAddress []string `bson:"serverAddress,omitempty"`
 }
 
 type Role string
@@ -852,6 +876,9 @@ func (db *Database) UpsertUser(user *User) error {
 	if len(user.OtherDBRoles) > 0 && db.Name != "admin" && db.Name != "$external" {
 		return fmt.Errorf("user with OtherDBRoles is only supported in the admin or $external databases")
 	}
+	if len(user.AuthenticationRestrictions) > 0 && user.UserSource != "" {
+		return fmt.Errorf("user has both AuthenticationRestrictions and UserSource set")
+	}
 
 	// Attempt to run this using 2.6+ commands.
 	rundb := db
@@ -869,6 +896,9 @@ func (db *Database) UpsertUser(user *User) error {
 	}
 
 	// Command does not exist. Fallback to pre-2.6 behavior.
+	if len(user.AuthenticationRestrictions) > 0 || len(user.Mechanisms) > 0 {
+		return fmt.Errorf("user AuthenticationRestrictions and Mechanisms require MongoDB 3.6+")
+	}
 	var set, unset bson.D
 	if user.Password != "" {
 		psum := md5.New()
@@ -920,24 +950,32 @@ func isAuthError(err error) bool {
 	return ok && e.Code == 13
 }
 
+// isUnknownField returns whether err reports a command field
+// unsupported by the server.
+func isUnknownField(err error) bool {
+	e, ok := err.(*QueryError)
+	return ok && (e.Code == 2 || e.Code == 9 || e.Code == 40415)
+}
+
 func (db *Database) runUserCmd(cmdName string, user *User) error {
 	cmd := make(bson.D, 0, 16)
 	cmd = append(cmd, bson.DocElem{cmdName, user.Username})
 	if user.Password != "" {
 		cmd = append(cmd, bson.DocElem{"pwd", user.Password})
 	}
-	var roles []interface{}
-	for _, role := range user.Roles {
-		roles = append(roles, role)
-	}
-	for db, dbroles := range user.OtherDBRoles {
-		for _, role := range dbroles {
-			roles = append(roles, bson.D{{"role", role}, {"db", db}})
-		}
-	}
+	roles := roleArgs(user.Roles, user.OtherDBRoles)
 	if roles != nil || user.Roles != nil || cmdName == "createUser" {
 		cmd = append(cmd, bson.DocElem{"roles", roles})
 	}
+	if user.CustomData != nil {
+		cmd = append(cmd, bson.DocElem{"customData", user.CustomData})
+	}
+	if user.AuthenticationRestrictions != nil {
+		cmd = append(cmd, bson.DocElem{"authenticationRestrictions", user.AuthenticationRestrictions})
+	}
+	if user.Mechanisms != nil {
+		cmd = append(cmd, bson.DocElem{"mechanisms", user.Mechanisms})
+	}
 	err := db.Run(cmd, nil)
 	if !isNoCmd(err) && user.UserSource != "" && (user.UserSource != "$external" || db.Name != "$external") {
 		return fmt.Errorf("MongoDB 2.6+ does not support the UserSource setting")
@@ -996,6 +1034,383 @@ func (db *Database) RemoveUser(user string) error {
 	return err
 }
 
+// roleArgs returns the role documents understood by the user and role
+// management commands for roles in the database the command runs in,
+// and for roles in other databases.
+func roleArgs(roles []Role, otherDBRoles map[string][]Role) []interface{} {
+	var args []interface{}
+	for _, role := range roles {
+		args = append(args, role)
+	}
+	for db, dbroles := range otherDBRoles {
+		for _, role := range dbroles {
+			args = append(args, bson.D{{"role", role}, {"db", db}})
+		}
+	}
+	return args
+}
+
+type roleRef struct {
+	Role Role   "role"
+	DB   string "db"
+}
+
+// splitRoleRefs returns the provided role references split into the
+// roles in the db database and the roles in other databases.
+func splitRoleRefs(dbname string, refs []roleRef) (roles []Role, otherDBRoles map[string][]Role) {
+	roles = []Role{}
+	for _, ref := range refs {
+		if ref.DB == dbname || ref.DB == "" {
+			roles = append(roles, ref.Role)
+			continue
+		}
+		if otherDBRoles == nil {
+			otherDBRoles = make(map[string][]Role)
+		}
+		otherDBRoles[ref.DB] = append(otherDBRoles[ref.DB], ref.Role)
+	}
+	return roles, otherDBRoles
+}
+
+// Resource identifies what a privilege applies to. It holds either a
+// database and collection pair, where an empty name matches any database
+// or collection, or one of the Cluster or AnyResource flags.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/resource-document/
+//
+type Resource struct {
+	DB          string
+	Collection  string
+	Cluster     bool
+	AnyResource bool
+}
+
+// GetBSON implements bson.Getter.
+func (r Resource) GetBSON() (interface{}, error) {
+	if r.Cluster {
+		return bson.D{{"cluster", true}}, nil
+	}
+	if r.AnyResource {
+		return bson.D{{"anyResource", true}}, nil
+	}
+	return bson.D{{"db", r.DB}, {"collection", r.Collection}}, nil
+}
+
+// SetBSON implements bson.Setter.
+func (r *Resource) SetBSON(raw bson.Raw) error {
+	var doc struct {
+		DB          string "db"
+		Collection  string "collection"
+		Cluster     bool   "cluster"
+		AnyResource bool   "anyResource"
+	}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+	*r = Resource(doc)
+	return nil
+}
+
+// Privilege holds the actions allowed on a resource.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/privilege-actions/
+//
+type Privilege struct {
+	Resource Resource `bson:"resource"`
+	Actions  []string `bson:"actions"`
+}
+
+// RoleInfo holds the definition of a user-defined role, or of a role
+// obtained via RolesInfo.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/security-user-defined-roles/
+//
+type RoleInfo struct {
+	// Name is the name of the role, which is unique within its database.
+	Name string
+
+	// Privileges holds the privileges granted by the role itself.
+	Privileges []Privilege
+
+	// Roles and OtherDBRoles hold the roles this role inherits
+	// privileges from, within its database and within other
+	// databases respectively, as done in User.
+	Roles        []Role
+	OtherDBRoles map[string][]Role
+
+	// AuthenticationRestrictions limits the addresses users with
+	// this role may authenticate from and to. Requires MongoDB 3.6+.
+	AuthenticationRestrictions []AuthenticationRestriction
+
+	// IsBuiltin reports whether the role is provided by the
+	// server. It's only set by RolesInfo.
+	IsBuiltin bool
+}
+
+func (db *Database) runRoleCmd(cmdName string, role *RoleInfo) error {
+	if role.Name == "" {
+		return fmt.Errorf("role has no Name")
+	}
+	cmd := make(bson.D, 0, 8)
+	cmd = append(cmd, bson.DocElem{cmdName, role.Name})
+	if role.Privileges != nil || cmdName == "createRole" {
+		privileges := role.Privileges
+		if privileges == nil {
+			privileges = []Privilege{}
+		}
+		cmd = append(cmd, bson.DocElem{"privileges", privileges})
+	}
+	roles := roleArgs(role.Roles, role.OtherDBRoles)
+	if roles != nil || role.Roles != nil || cmdName == "createRole" {
+		if roles == nil {
+			roles = []interface{}{}
+		}
+		cmd = append(cmd, bson.DocElem{"roles", roles})
+	}
+	if role.AuthenticationRestrictions != nil {
+		cmd = append(cmd, bson.DocElem{"authenticationRestrictions", role.AuthenticationRestrictions})
+	}
+	return db.Run(cmd, nil)
+}
+
+// CreateRole creates the user-defined role within the db database.
+// Role management requires MongoDB 2.6+.
+//
+// For example:
+//
+//     err := db.CreateRole(&mgo.RoleInfo{
+//         Name: "reporter",
+//         Privileges: []mgo.Privilege{{
+//             Resource: mgo.Resource{DB: "mydb", Collection: "reports"},
+//             Actions:  []string{"find"},
+//         }},
+//         Roles: []mgo.Role{mgo.RoleRead},
+//     })
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/createRole/
+//
+func (db *Database) CreateRole(role *RoleInfo) error {
+	return db.runRoleCmd("createRole", role)
+}
+
+// UpdateRole replaces the privileges, inherited roles and authentication
+// restrictions of the existing user-defined role within the db database.
+// Fields left nil are not changed.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/updateRole/
+//
+func (db *Database) UpdateRole(role *RoleInfo) error {
+	err := db.runRoleCmd("updateRole", role)
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DropRole removes the user-defined role with the provided name
+// from the db database.
+func (db *Database) DropRole(name string) error {
+	err := db.Run(bson.D{{"dropRole", name}}, nil)
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GrantRolesToUser grants the provided roles within the db
+// database to the user with the provided name in that database.
+func (db *Database) GrantRolesToUser(username string, roles ...Role) error {
+	return db.runGrantCmd("grantRolesToUser", username, "roles", roleArgs(roles, nil))
+}
+
+// RevokeRolesFromUser revokes the provided roles within the db
+// database from the user with the provided name in that database.
+func (db *Database) RevokeRolesFromUser(username string, roles ...Role) error {
+	return db.runGrantCmd("revokeRolesFromUser", username, "roles", roleArgs(roles, nil))
+}
+
+// GrantPrivilegesToRole adds the provided privileges to the
+// user-defined role with the provided name in the db database.
+func (db *Database) GrantPrivilegesToRole(name string, privileges ...Privilege) error {
+	return db.runGrantCmd("grantPrivilegesToRole", name, "privileges", privileges)
+}
+
+func (db *Database) runGrantCmd(cmdName, name, field string, values interface{}) error {
+	err := db.Run(bson.D{{cmdName, name}, {field, values}}, nil)
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+type userInfoDoc struct {
+	User                       string
+	CustomData                 interface{} "customData"
+	Roles                      []roleRef   "roles"
+	AuthenticationRestrictions []bson.Raw  "authenticationRestrictions"
+	Mechanisms                 []string    "mechanisms"
+}
+
+// UsersInfo returns the details of the users with the provided names
+// in the db database, or of all users in db if no names are provided.
+// Roles granted within other databases are reported in OtherDBRoles.
+// Passwords are never reported.
+//
+// Servers prior to 2.6 have no usersInfo command, so the users are read
+// from the system.users collection instead. Users of these servers that
+// have no roles are reported with the roles matching their readOnly flag.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/usersInfo/
+//
+func (db *Database) UsersInfo(names ...string) ([]User, error) {
+	var arg interface{} = 1
+	if len(names) > 0 {
+		arg = names
+	}
+	var result struct{ Users []userInfoDoc }
+	err := db.Run(bson.D{{"usersInfo", arg}, {"showAuthenticationRestrictions", true}}, &result)
+	if qerr, ok := err.(*QueryError); ok && isUnknownField(err) {
+		// Servers prior to 3.6 reject the unknown option.
+		debugf("usersInfo with authentication restrictions failed (%s); retrying without", qerr.Message)
+		err = db.Run(bson.D{{"usersInfo", arg}}, &result)
+	}
+	if isNoCmd(err) {
+		return db.legacyUsersInfo(names)
+	}
+	if err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(result.Users))
+	for _, doc := range result.Users {
+		user := User{
+			Username:   doc.User,
+			CustomData: doc.CustomData,
+			Mechanisms: doc.Mechanisms,
+		}
+		user.Roles, user.OtherDBRoles = splitRoleRefs(db.Name, doc.Roles)
+		user.AuthenticationRestrictions, err = parseRestrictions(doc.AuthenticationRestrictions)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// legacyUsersInfo implements UsersInfo for servers prior to 2.6.
+func (db *Database) legacyUsersInfo(names []string) ([]User, error) {
+	var query interface{}
+	if len(names) > 0 {
+		query = bson.D{{"user", bson.D{{"$in", names}}}}
+	}
+	var docs []struct {
+		User     `bson:",inline"`
+		ReadOnly bool `bson:"readOnly"`
+	}
+	if err := db.C("system.users").Find(query).Sort("user").All(&docs); err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(docs))
+	for _, doc := range docs {
+		user := doc.User
+		user.PasswordHash = ""
+		if user.Roles == nil && user.OtherDBRoles == nil && user.UserSource == "" {
+			// Old-style document, from before 2.4.
+			if doc.ReadOnly {
+				user.Roles = []Role{RoleRead}
+			} else {
+				user.Roles = []Role{RoleReadWrite, RoleDBAdmin, RoleUserAdmin}
+			}
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// parseRestrictions returns the authentication restrictions reported by
+// the server, which groups them in lists when inherited from roles.
+func parseRestrictions(raws []bson.Raw) ([]AuthenticationRestriction, error) {
+	var restrictions []AuthenticationRestriction
+	for _, raw := range raws {
+		if raw.Kind == 0x04 {
+			var group []AuthenticationRestriction
+			if err := raw.Unmarshal(&group); err != nil {
+				return nil, err
+			}
+			restrictions = append(restrictions, group...)
+			continue
+		}
+		var restriction AuthenticationRestriction
+		if err := raw.Unmarshal(&restriction); err != nil {
+			return nil, err
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	return restrictions, nil
+}
+
+type roleInfoDoc struct {
+	Role                       string
+	DB                         string
+	IsBuiltin                  bool        "isBuiltin"
+	Privileges                 []Privilege "privileges"
+	Roles                      []roleRef   "roles"
+	AuthenticationRestrictions []bson.Raw  "authenticationRestrictions"
+}
+
+// RolesInfo returns the definitions of the roles with the provided
+// names in the db database, or of all user-defined roles in db if no
+// names are provided. Role management requires MongoDB 2.6+.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/rolesInfo/
+//
+func (db *Database) RolesInfo(names ...string) ([]RoleInfo, error) {
+	var arg interface{} = 1
+	if len(names) > 0 {
+		arg = names
+	}
+	cmd := bson.D{{"rolesInfo", arg}, {"showPrivileges", true}}
+	var result struct{ Roles []roleInfoDoc }
+	err := db.Run(append(cmd, bson.DocElem{"showAuthenticationRestrictions", true}), &result)
+	if qerr, ok := err.(*QueryError); ok && isUnknownField(err) {
+		// Servers prior to 3.6 reject the unknown option.
+		debugf("rolesInfo with authentication restrictions failed (%s); retrying without", qerr.Message)
+		err = db.Run(cmd, &result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]RoleInfo, 0, len(result.Roles))
+	for _, doc := range result.Roles {
+		role := RoleInfo{
+			Name:       doc.Role,
+			Privileges: doc.Privileges,
+			IsBuiltin:  doc.IsBuiltin,
+		}
+		role.Roles, role.OtherDBRoles = splitRoleRefs(db.Name, doc.Roles)
+		role.AuthenticationRestrictions, err = parseRestrictions(doc.AuthenticationRestrictions)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
 type indexSpec struct {
 	Name, NS         string
 	Key              bson.D
@@ -1013,6 +1428,11 @@ type indexSpec struct {
 	TextIndexVersion int     "textIndexVersion,omitempty"
 
 	Collation *Collation "collation,omitempty"
+
+	PartialFilterExpression bson.M      "partialFilterExpression,omitempty"
+	WildcardProjection      bson.M      "wildcardProjection,omitempty"
+	Hidden                  bool        ",omitempty"
+	StorageEngine           interface{} "storageEngine,omitempty"
 }
 
 type Index struct {
@@ -1054,6 +1474,22 @@ type Index struct {
 
 	// Collation defines the collation to use for the index.
 	Collation *Collation
+
+	// PartialFilter restricts the index to documents matching
+	// the provided filter expression. Requires MongoDB 3.2+.
+	PartialFilter bson.M
+
+	// WildcardProjection selects the fields to include in or exclude
+	// from a wildcard index with a key such as "$**". Requires MongoDB 4.2+.
+	WildcardProjection bson.M
+
+	// Hidden prevents the query planner from using the index, while
+	// still keeping it up to date. Requires MongoDB 4.4+.
+	Hidden bool
+
+	// StorageEngine allows specifying index options for the
+	// storage engine in use, keyed by its name.
+	StorageEngine interface{}
 }
 
 type Collation struct {
@@ -1121,7 +1557,7 @@ func parseIndexKey(key []string) (*indexKeyInfo, error) {
 		}
 		var kind string
 		if field != "" {
-			if field[0] == '$' {
+			if field[0] == '$' && field != "$**" {
 				if c := strings.Index(field, ":"); c > 1 && c < len(field)-1 {
 					kind = field[1:c]
 					field = field[c+1:]
@@ -1265,54 +1701,89 @@ func (c *Collection) EnsureIndexKey(key ...string) error {
 //     http://www.mongodb.org/display/DOCS/Multikeys
 //
 func (c *Collection) EnsureIndex(index Index) error {
-	keyInfo, err := parseIndexKey(index.Key)
-	if err != nil {
-		return err
-	}
+	return c.EnsureIndexes(index)
+}
 
+// EnsureIndexes ensures all the provided indexes exist, creating the missing
+// ones with a single request to the server when it supports the createIndexes
+// command (MongoDB 2.6+). See EnsureIndex for details on each index.
+//
+// For example:
+//
+//     err := collection.EnsureIndexes(
+//         mgo.Index{Key: []string{"lastname", "firstname"}},
+//         mgo.Index{Key: []string{"email"}, Unique: true},
+//         mgo.Index{
+//             Key:           []string{"expires"},
+//             ExpireAfter:   24 * time.Hour,
+//             PartialFilter: bson.M{"temporary": true},
+//         },
+//     )
+//
+func (c *Collection) EnsureIndexes(indexes ...Index) error {
 	session := c.Database.Session
-	cacheKey := c.FullName + "\x00" + keyInfo.name
-	if session.cluster().HasCachedIndex(cacheKey) {
-		return nil
-	}
+	var specs []indexSpec
+	var cacheKeys []string
+	for _, index := range indexes {
+		keyInfo, err := parseIndexKey(index.Key)
+		if err != nil {
+			return err
+		}
 
-	spec := indexSpec{
-		Name:             keyInfo.name,
-		NS:               c.FullName,
-		Key:              keyInfo.key,
-		Unique:           index.Unique,
-		DropDups:         index.DropDups,
-		Background:       index.Background,
-		Sparse:           index.Sparse,
-		Bits:             index.Bits,
-		Min:              index.Minf,
-		Max:              index.Maxf,
-		BucketSize:       index.BucketSize,
-		ExpireAfter:      int(index.ExpireAfter / time.Second),
-		Weights:          keyInfo.weights,
-		DefaultLanguage:  index.DefaultLanguage,
-		LanguageOverride: index.LanguageOverride,
-		Collation:        index.Collation,
-	}
+		cacheKey := c.FullName + "\x00" + keyInfo.name
+		if session.cluster().HasCachedIndex(cacheKey) {
+			continue
+		}
 
-	if spec.Min == 0 && spec.Max == 0 {
-		spec.Min = float64(index.Min)
-		spec.Max = float64(index.Max)
-	}
+		spec := indexSpec{
+			Name:             keyInfo.name,
+			NS:               c.FullName,
+			Key:              keyInfo.key,
+			Unique:           index.Unique,
+			DropDups:         index.DropDups,
+			Background:       index.Background,
+			Sparse:           index.Sparse,
+			Bits:             index.Bits,
+			Min:              index.Minf,
+			Max:              index.Maxf,
+			BucketSize:       index.BucketSize,
+			ExpireAfter:      int(index.ExpireAfter / time.Second),
+			Weights:          keyInfo.weights,
+			DefaultLanguage:  index.DefaultLanguage,
+			LanguageOverride: index.LanguageOverride,
+			Collation:        index.Collation,
+
+			PartialFilterExpression: index.PartialFilter,
+			WildcardProjection:      index.WildcardProjection,
+			Hidden:                  index.Hidden,
+			StorageEngine:           index.StorageEngine,
+		}
 
-	if index.Name != "" {
-		spec.Name = index.Name
-	}
+		if spec.Min == 0 && spec.Max == 0 {
+			spec.Min = float64(index.Min)
+			spec.Max = float64(index.Max)
+		}
 
-NextField:
-	for name, weight := range index.Weights {
-		for i, elem := range spec.Weights {
-			if elem.Name == name {
-				spec.Weights[i].Value = weight
-				continue NextField
+		if index.Name != "" {
+			spec.Name = index.Name
+		}
+
+	NextField:
+		for name, weight := range index.Weights {
+			for i, elem := range spec.Weights {
+				if elem.Name == name {
+					spec.Weights[i].Value = weight
+					continue NextField
+				}
 			}
+			panic("weight provided for field that is not part of index key: " + name)
 		}
-		panic("weight provided for field that is not part of index key: " + name)
+
+		specs = append(specs, spec)
+		cacheKeys = append(cacheKeys, cacheKey)
+	}
+	if len(specs) == 0 {
+		return nil
 	}
 
 	cloned := session.Clone()
@@ -1322,17 +1793,70 @@ NextField:
 	db := c.Database.With(cloned)
 
 	// Try with a command first.
-	err = db.Run(bson.D{{"createIndexes", c.Name}, {"indexes", []indexSpec{spec}}}, nil)
+	created := len(specs)
+	err := db.Run(bson.D{{"createIndexes", c.Name}, {"indexes", specs}}, nil)
 	if isNoCmd(err) {
 		// Command not yet supported. Insert into the indexes collection instead.
-		err = db.C("system.indexes").Insert(&spec)
+		for created = 0; created < len(specs); created++ {
+			err = db.C("system.indexes").Insert(&specs[created])
+			if err != nil {
+				break
+			}
+		}
+	} else if err != nil {
+		created = 0
 	}
-	if err == nil {
+	for _, cacheKey := range cacheKeys[:created] {
 		session.cluster().CacheIndex(cacheKey, true)
 	}
 	return err
 }
 
+// IndexChange holds the changes to apply to an existing index via
+// Collection.ModifyIndex. Nil fields are left unchanged.
+type IndexChange struct {
+	// Hidden hides the index from the query planner, or makes
+	// it visible again. Requires MongoDB 4.4+.
+	Hidden *bool
+
+	// ExpireAfter changes the time after which documents
+	// are removed from the collection by a TTL index.
+	ExpireAfter *time.Duration
+}
+
+// ModifyIndex changes properties of the existing index with the
+// provided name, as defined by change. At least one field of
+// change must be set.
+//
+// For example:
+//
+//     hidden := true
+//     err := collection.ModifyIndex("lastname_1", mgo.IndexChange{Hidden: &hidden})
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/collMod/#index-options
+//
+func (c *Collection) ModifyIndex(name string, change IndexChange) error {
+	if change.Hidden == nil && change.ExpireAfter == nil {
+		return errors.New("no changes provided for index " + name)
+	}
+	index := bson.D{{"name", name}}
+	if change.Hidden != nil {
+		index = append(index, bson.DocElem{"hidden", *change.Hidden})
+	}
+	if change.ExpireAfter != nil {
+		index = append(index, bson.DocElem{"expireAfterSeconds", int(*change.ExpireAfter / time.Second)})
+	}
+
+	session := c.Database.Session.Clone()
+	defer session.Close()
+	session.SetMode(Strong, false)
+
+	db := c.Database.With(session)
+	return db.Run(bson.D{{"collMod", c.Name}, {"index", index}}, nil)
+}
+
 // DropIndex drops the index with the provided key from the c collection.
 //
 // See EnsureIndex for details on the accepted key variants.
@@ -1508,6 +2032,11 @@ func indexFromSpec(spec indexSpec) Index {
 		LanguageOverride: spec.LanguageOverride,
 		ExpireAfter:      time.Duration(spec.ExpireAfter) * time.Second,
 		Collation:        spec.Collation,
+
+		PartialFilter:      spec.PartialFilterExpression,
+		WildcardProjection: spec.WildcardProjection,
+		Hidden:             spec.Hidden,
+		StorageEngine:      spec.StorageEngine,
 	}
 	if float64(int(spec.Min)) == spec.Min && float64(int(spec.Max)) == spec.Max {
 		index.Min = int(spec.Min)
@@ -2172,6 +2701,7 @@ type Pipe struct {
 	pipeline   interface{}
 	allowDisk  bool
 	batchSize  int
+	verbosity  string
 }
 
 type pipeCmd struct {
@@ -2303,11 +2833,102 @@ func (c *Collection) NewIter(session *Session, firstBatch []bson.Raw, cursorId i
 	for _, doc := range firstBatch {
 		iter.docData.Push(doc.Data)
 	}
-	if cursorId != 0 {
-		iter.op.cursorId = cursorId
+	if cursorId != 0 {
+		iter.op.cursorId = cursorId
+		iter.op.collection = c.FullName
+		iter.op.replyFunc = iter.replyFunc()
+	}
+	return iter
+}
+
+// IterState holds the details necessary to resume iterating over the
+// results of a cursor, possibly from a different process.
+// See the Iter.State and Collection.NewIterFromState methods.
+type IterState struct {
+	CursorId  int64      `bson:"cursorId"`
+	Namespace string     `bson:"ns"`              // "db.collection"
+	Server    string     `bson:"server"`          // Address of the server holding the cursor
+	Limit     int32      `bson:"limit,omitempty"` // Number of documents left, or zero if unlimited
+	FindCmd   bool       `bson:"findCmd,omitempty"`
+	Batch     []bson.Raw `bson:"batch,omitempty"` // Documents received but not yet iterated over
+}
+
+// State detaches the cursor from the iterator and returns the details
+// necessary to resume the iteration elsewhere via NewIterFromState.
+// Any documents already received from the server but not yet returned
+// by Next are included in the state.
+//
+// After State returns successfully the iterator is exhausted, and
+// closing it will not kill the cursor at the server. The new iterator
+// created from the state becomes responsible for doing so.
+func (iter *Iter) State() (*IterState, error) {
+	iter.m.Lock()
+	defer iter.m.Unlock()
+	for iter.docsToReceive > 0 {
+		iter.gotReply.Wait()
+	}
+	if iter.err != nil && iter.err != ErrNotFound {
+		return nil, iter.err
+	}
+	state := &IterState{
+		CursorId:  iter.op.cursorId,
+		Namespace: iter.op.collection,
+		Limit:     iter.limit,
+		FindCmd:   iter.findCmd,
+	}
+	if iter.server != nil {
+		state.Server = iter.server.Addr
+	}
+	for iter.docData.Len() > 0 {
+		state.Batch = append(state.Batch, bson.Raw{Kind: 0x03, Data: iter.docData.Pop().([]byte)})
+	}
+	iter.op.cursorId = 0
+	return state, nil
+}
+
+// NewIterFromState returns a new iterator that continues iterating over
+// the cursor described by state, as previously obtained via Iter.State,
+// possibly in a different process. Further documents are requested from
+// the same server that holds the cursor, regardless of the session mode.
+//
+// If the state refers to a namespace, that's used in preference to the
+// collection name when requesting more documents from the server.
+func (c *Collection) NewIterFromState(state *IterState) *Iter {
+	session := c.Database.Session
+	session.m.RLock()
+	prefetch := session.queryConfig.prefetch
+	batch := session.queryConfig.op.limit
+	syncTimeout := session.syncTimeout
+	session.m.RUnlock()
+
+	iter := &Iter{
+		session:  session,
+		prefetch: prefetch,
+		limit:    state.Limit,
+		timeout:  -1,
+		findCmd:  state.FindCmd,
+	}
+	iter.gotReply.L = &iter.m
+	for _, doc := range state.Batch {
+		iter.docData.Push(doc.Data)
+	}
+	if state.CursorId == 0 {
+		return iter
+	}
+
+	server, err := session.cluster().Server(state.Server, syncTimeout)
+	if err != nil {
+		iter.err = err
+		return iter
+	}
+	iter.server = server
+	iter.op.cursorId = state.CursorId
+	iter.op.collection = state.Namespace
+	if iter.op.collection == "" {
 		iter.op.collection = c.FullName
-		iter.op.replyFunc = iter.replyFunc()
 	}
+	iter.op.limit = batch
+	iter.op.replyFunc = iter.replyFunc()
 	return iter
 }
 
@@ -2343,6 +2964,9 @@ func (p *Pipe) One(result interface{}) error {
 //         fmt.Printf("Explain: %#v\n", m)
 //     }
 //
+// The result may also be an *ExplainResult, which decodes the details
+// consistently across server versions.
+//
 func (p *Pipe) Explain(result interface{}) error {
 	c := p.collection
 	cmd := pipeCmd{
@@ -2351,9 +2975,22 @@ func (p *Pipe) Explain(result interface{}) error {
 		AllowDisk: p.allowDisk,
 		Explain:   true,
 	}
+	if p.verbosity != "" {
+		cmd.Explain = false
+		cmd.Cursor = &pipeCmdCursor{}
+		return c.Database.Run(bson.D{{"explain", cmd}, {"verbosity", p.verbosity}}, result)
+	}
 	return c.Database.Run(cmd, result)
 }
 
+// SetExplainVerbosity defines the amount of detail reported by Explain,
+// which must be one of ExplainQueryPlanner, ExplainExecutionStats or
+// ExplainAllPlansExecution. This requires MongoDB 3.6+.
+func (p *Pipe) SetExplainVerbosity(verbosity string) *Pipe {
+	p.verbosity = verbosity
+	return p
+}
+
 // AllowDiskUse enables writing to the "<dbpath>/_tmp" server directory so
 // that aggregation pipelines do not have to be held entirely in memory.
 func (p *Pipe) AllowDiskUse() *Pipe {
@@ -2382,6 +3019,8 @@ type LastError struct {
 	UpsertedId      interface{} `bson:"upserted"`
 
 	modified int
+	upserted []writeCmdUpserted
+	wcerr    *LastError
 	ecases   []BulkErrorCase
 }
 
@@ -2419,6 +3058,9 @@ func IsDup(err error) bool {
 	case *QueryError:
 		return e.Code == 11000 || e.Code == 11001 || e.Code == 12582
 	case *BulkError:
+		if len(e.ecases) == 0 {
+			return false
+		}
 		for _, ecase := range e.ecases {
 			if !IsDup(ecase.Err) {
 				return false
@@ -2676,6 +3318,34 @@ type CollectionInfo struct {
 	// storage engine in use. The map keys must hold the storage engine
 	// name for which options are being specified.
 	StorageEngine interface{}
+
+	// TimeSeries creates a time-series collection, which stores
+	// measurements taken over time efficiently. Requires MongoDB 5.0+.
+	TimeSeries *TimeSeriesInfo
+
+	// ExpireAfter defines the time after which documents are removed
+	// from a time-series collection, based on their time field.
+	ExpireAfter time.Duration
+}
+
+// TimeSeriesInfo holds the options of a time-series collection.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/timeseries-collections/
+//
+type TimeSeriesInfo struct {
+	// TimeField is the name of the field holding the date
+	// of each measurement. It must be set.
+	TimeField string `bson:"timeField"`
+
+	// MetaField optionally names the field holding metadata
+	// identifying the source of each measurement.
+	MetaField string `bson:"metaField,omitempty"`
+
+	// Granularity may be set to "seconds" (the default), "minutes"
+	// or "hours" to match the interval between measurements.
+	Granularity string `bson:"granularity,omitempty"`
 }
 
 // Create explicitly creates the c collection with details of info.
@@ -2719,9 +3389,115 @@ func (c *Collection) Create(info *CollectionInfo) error {
 	if info.StorageEngine != nil {
 		cmd = append(cmd, bson.DocElem{"storageEngine", info.StorageEngine})
 	}
+	if info.TimeSeries != nil {
+		if info.TimeSeries.TimeField == "" {
+			return fmt.Errorf("Collection.Create: with TimeSeries, TimeField must also be set")
+		}
+		cmd = append(cmd, bson.DocElem{"timeseries", info.TimeSeries})
+	}
+	if info.ExpireAfter > 0 {
+		cmd = append(cmd, bson.DocElem{"expireAfterSeconds", int(info.ExpireAfter / time.Second)})
+	}
+	return c.Database.Run(cmd, nil)
+}
+
+// Rename renames the c collection to toName within the toDB database,
+// which may be the database c is in. If dropTarget is true an existing
+// collection named toName is dropped first, otherwise renaming onto an
+// existing collection fails.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/renameCollection/
+//
+func (c *Collection) Rename(toDB, toName string, dropTarget bool) error {
+	cmd := bson.D{
+		{"renameCollection", c.FullName},
+		{"to", toDB + "." + toName},
+		{"dropTarget", dropTarget},
+	}
+	return c.Database.Session.Run(cmd, nil)
+}
+
+// CollectionChange holds the changes to apply to an existing collection
+// via Collection.Modify. Zero and nil fields are left unchanged.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/collMod/
+//
+type CollectionChange struct {
+	// Validator, ValidationLevel and ValidationAction replace the
+	// respective document validation settings. See CollectionInfo
+	// for details.
+	Validator        interface{}
+	ValidationLevel  string
+	ValidationAction string
+
+	// ExpireAfter changes the time after which documents are
+	// removed from a time-series collection. TTL indexes are
+	// changed via Collection.ModifyIndex instead.
+	ExpireAfter *time.Duration
+
+	// ChangeStreamPreAndPostImages enables or disables recording the
+	// state of documents before and after changes, for reporting them
+	// in change streams. Requires MongoDB 6.0+.
+	ChangeStreamPreAndPostImages *bool
+}
+
+// Modify changes the options of the c collection as defined by change.
+//
+// For example:
+//
+//     err := collection.Modify(&mgo.CollectionChange{
+//         Validator:       bson.M{"name": bson.M{"$exists": true}},
+//         ValidationLevel: "moderate",
+//     })
+//
+func (c *Collection) Modify(change *CollectionChange) error {
+	cmd := bson.D{{"collMod", c.Name}}
+	if change.Validator != nil {
+		cmd = append(cmd, bson.DocElem{"validator", change.Validator})
+	}
+	if change.ValidationLevel != "" {
+		cmd = append(cmd, bson.DocElem{"validationLevel", change.ValidationLevel})
+	}
+	if change.ValidationAction != "" {
+		cmd = append(cmd, bson.DocElem{"validationAction", change.ValidationAction})
+	}
+	if change.ExpireAfter != nil {
+		cmd = append(cmd, bson.DocElem{"expireAfterSeconds", int(*change.ExpireAfter / time.Second)})
+	}
+	if change.ChangeStreamPreAndPostImages != nil {
+		cmd = append(cmd, bson.DocElem{"changeStreamPreAndPostImages", bson.D{{"enabled", *change.ChangeStreamPreAndPostImages}}})
+	}
 	return c.Database.Run(cmd, nil)
 }
 
+// CreateView creates a read-only view named name in the db database,
+// presenting the documents of the source collection or view as
+// transformed by the aggregation pipeline. The collation is optional.
+//
+// For example:
+//
+//     pipeline := []bson.M{{"$match": bson.M{"active": true}}}
+//     err := db.CreateView("activeusers", "users", pipeline, nil)
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/views/
+//
+func (db *Database) CreateView(name, source string, pipeline interface{}, collation *Collation) error {
+	if pipeline == nil {
+		pipeline = []bson.M{}
+	}
+	cmd := bson.D{{"create", name}, {"viewOn", source}, {"pipeline", pipeline}}
+	if collation != nil {
+		cmd = append(cmd, bson.DocElem{"collation", collation})
+	}
+	return db.Run(cmd, nil)
+}
+
 // Batch sets the batch size used when fetching documents from the database.
 // It's possible to change this setting on a per-session basis as well, using
 // the Batch method of Session.
@@ -2871,6 +3647,9 @@ func (q *Query) Sort(fields ...string) *Query {
 //         fmt.Printf("Explain: %#v\n", m)
 //     }
 //
+// The result may also be an *ExplainResult, which decodes the details
+// consistently across server versions.
+//
 // Relevant documentation:
 //
 //     http://www.mongodb.org/display/DOCS/Optimization
@@ -2894,6 +3673,22 @@ func (q *Query) Explain(result interface{}) error {
 
 // TODO: Add Collection.Explain. See https://goo.gl/1MDlvz.
 
+// SetExplainVerbosity defines the amount of detail reported by Explain,
+// which must be one of ExplainQueryPlanner, ExplainExecutionStats or
+// ExplainAllPlansExecution. The verbosity is only honored by MongoDB 3.2+,
+// with older servers reporting all the details they have available.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/explain/#explain-command-verbosity
+//
+func (q *Query) SetExplainVerbosity(verbosity string) *Query {
+	q.m.Lock()
+	q.op.explainVerbosity = verbosity
+	q.m.Unlock()
+	return q
+}
+
 // Hint will include an explicit "hint" in the query to force the server
 // to use a specified index, potentially improving performance in some
 // situations.  The provided parameters are the fields that compose the
@@ -3084,6 +3879,13 @@ func (q *Query) One(result interface{}) (err error) {
 	op := q.op // Copy.
 	q.m.Unlock()
 
+	ae := session.encrypter()
+	if ae != nil {
+		if op.query, err = ae.encryptQuery(op.collection, op.query); err != nil {
+			return err
+		}
+	}
+
 	socket, err := session.acquireSocket(true)
 	if err != nil {
 		return err
@@ -3123,6 +3925,11 @@ func (q *Query) One(result interface{}) (err error) {
 		data = findReply.Cursor.FirstBatch[0].Data
 	}
 	if result != nil {
+		if ae != nil {
+			if data, err = ae.decryptDoc(data); err != nil {
+				return err
+			}
+		}
 		err = bson.Unmarshal(data, result)
 		if err == nil {
 			debugf("Query %p document unmarshaled: %#v", q, result)
@@ -3170,6 +3977,7 @@ func prepareFindOp(socket *mongoSocket, op *queryOp, limit int32) bool {
 	}
 
 	explain := op.options.Explain
+	verbosity := op.explainVerbosity
 
 	op.collection = op.collection[:nameDot] + ".$cmd"
 	op.query = &find
@@ -3179,7 +3987,11 @@ func prepareFindOp(socket *mongoSocket, op *queryOp, limit int32) bool {
 	op.hasOptions = false
 
 	if explain {
-		op.query = bson.D{{"explain", op.query}}
+		cmd := bson.D{{"explain", op.query}}
+		if verbosity != "" {
+			cmd = append(cmd, bson.DocElem{"verbosity", verbosity})
+		}
+		op.query = cmd
 		return false
 	}
 	return true
@@ -3340,6 +4152,126 @@ func (s *Session) FindRef(ref *DBRef) *Query {
 
 // CollectionNames returns the collection names present in the db database.
 func (db *Database) CollectionNames() (names []string, err error) {
+	// Only the name is decoded, so collections with options this
+	// driver cannot represent are still listed.
+	err = db.listCollections(nil, func(name string, raw bson.Raw) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CollectionSpec holds the details of a collection or view, as
+// reported by Database.ListCollections.
+type CollectionSpec struct {
+	Name string
+
+	// Type is "collection", "view" or "timeseries". Servers
+	// older than 3.4 only report plain collections.
+	Type string
+
+	// ReadOnly reports whether the collection refuses writes,
+	// as views do.
+	ReadOnly bool
+
+	// Capped, MaxBytes and MaxDocs report the settings of
+	// capped collections. See CollectionInfo for details.
+	Capped   bool
+	MaxBytes int
+	MaxDocs  int
+
+	// ViewOn and Pipeline hold the source collection and the
+	// aggregation pipeline a view is defined by.
+	ViewOn   string
+	Pipeline []bson.M
+
+	// TimeSeries holds the options of a time-series collection.
+	TimeSeries *TimeSeriesInfo
+
+	// Options holds all options the collection was created with.
+	Options bson.M
+}
+
+type collectionSpecDoc struct {
+	Name    string
+	Type    string
+	Options bson.Raw
+	Info    struct {
+		ReadOnly bool "readOnly"
+	}
+}
+
+type collectionSpecOptions struct {
+	Capped     bool
+	Size       int
+	Max        int
+	ViewOn     string "viewOn"
+	Pipeline   []bson.M
+	TimeSeries *TimeSeriesInfo "timeseries"
+}
+
+func (doc *collectionSpecDoc) spec() (*CollectionSpec, error) {
+	spec := &CollectionSpec{Name: doc.Name, Type: doc.Type, ReadOnly: doc.Info.ReadOnly}
+	if spec.Type == "" {
+		spec.Type = "collection"
+	}
+	if doc.Options.Kind != 0 {
+		var opts collectionSpecOptions
+		if err := doc.Options.Unmarshal(&opts); err != nil {
+			return nil, err
+		}
+		if err := doc.Options.Unmarshal(&spec.Options); err != nil {
+			return nil, err
+		}
+		spec.Capped = opts.Capped
+		spec.MaxBytes = opts.Size
+		spec.MaxDocs = opts.Max
+		spec.ViewOn = opts.ViewOn
+		spec.Pipeline = opts.Pipeline
+		spec.TimeSeries = opts.TimeSeries
+	}
+	return spec, nil
+}
+
+// ListCollections returns the details of the collections and views
+// present in the db database that match the optional filter, sorted
+// by name. The filter is matched against the documents returned by the
+// server, so {"type": "view"} selects only views, for example.
+// Filtering requires MongoDB 3.0+.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/listCollections/
+//
+func (db *Database) ListCollections(filter interface{}) (specs []CollectionSpec, err error) {
+	err = db.listCollections(filter, func(name string, raw bson.Raw) error {
+		var doc collectionSpecDoc
+		if err := raw.Unmarshal(&doc); err != nil {
+			return err
+		}
+		doc.Name = name
+		spec, err := doc.spec()
+		if err != nil {
+			return err
+		}
+		specs = append(specs, *spec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(collectionSpecSlice(specs))
+	return specs, nil
+}
+
+// listCollections calls visit with the name and the raw description of
+// each collection in the db database that matches filter, stopping at
+// the first error visit returns.
+func (db *Database) listCollections(filter interface{}, visit func(name string, raw bson.Raw) error) error {
 	// Clone session and set it to Monotonic mode so that the server
 	// used for the query may be safely obtained afterwards, if
 	// necessary for iteration when a cursor is received.
@@ -3353,7 +4285,11 @@ func (db *Database) CollectionNames() (names []string, err error) {
 		Collections []bson.Raw
 		Cursor      cursorData
 	}
-	err = db.With(cloned).Run(bson.D{{"listCollections", 1}, {"cursor", bson.D{{"batchSize", batchSize}}}}, &result)
+	cmd := bson.D{{"listCollections", 1}, {"cursor", bson.D{{"batchSize", batchSize}}}}
+	if filter != nil {
+		cmd = append(cmd, bson.DocElem{"filter", filter})
+	}
+	err := db.With(cloned).Run(cmd, &result)
 	if err == nil {
 		firstBatch := result.Collections
 		if firstBatch == nil {
@@ -3366,36 +4302,51 @@ func (db *Database) CollectionNames() (names []string, err error) {
 		} else {
 			iter = cloned.DB(ns[0]).C(ns[1]).NewIter(nil, firstBatch, result.Cursor.Id, nil)
 		}
-		var coll struct{ Name string }
-		for iter.Next(&coll) {
-			names = append(names, coll.Name)
-		}
-		if err := iter.Close(); err != nil {
-			return nil, err
+		var raw bson.Raw
+		for iter.Next(&raw) {
+			var doc struct{ Name string }
+			err := raw.Unmarshal(&doc)
+			if err == nil {
+				err = visit(doc.Name, raw)
+			}
+			if err != nil {
+				iter.Close()
+				return err
+			}
 		}
-		sort.Strings(names)
-		return names, err
+		return iter.Close()
 	}
 	if err != nil && !isNoCmd(err) {
-		return nil, err
+		return err
+	}
+	if filter != nil {
+		return errors.New("ListCollections: filtering requires MongoDB 3.0+")
 	}
 
 	// Command not yet supported. Query the database instead.
 	nameIndex := len(db.Name) + 1
 	iter := db.C("system.namespaces").Find(nil).Iter()
-	var coll struct{ Name string }
-	for iter.Next(&coll) {
-		if strings.Index(coll.Name, "$") < 0 || strings.Index(coll.Name, ".oplog.$") >= 0 {
-			names = append(names, coll.Name[nameIndex:])
+	var raw bson.Raw
+	for iter.Next(&raw) {
+		var doc struct{ Name string }
+		err := raw.Unmarshal(&doc)
+		if err == nil && (strings.Index(doc.Name, "$") < 0 || strings.Index(doc.Name, ".oplog.$") >= 0) {
+			err = visit(doc.Name[nameIndex:], raw)
+		}
+		if err != nil {
+			iter.Close()
+			return err
 		}
 	}
-	if err := iter.Close(); err != nil {
-		return nil, err
-	}
-	sort.Strings(names)
-	return names, nil
+	return iter.Close()
 }
 
+type collectionSpecSlice []CollectionSpec
+
+func (specs collectionSpecSlice) Len() int           { return len(specs) }
+func (specs collectionSpecSlice) Less(i, j int) bool { return specs[i].Name < specs[j].Name }
+func (specs collectionSpecSlice) Swap(i, j int)      { specs[i], specs[j] = specs[j], specs[i] }
+
 type dbNames struct {
 	Databases []struct {
 		Name  string
@@ -3443,6 +4394,15 @@ func (q *Query) Iter() *Iter {
 	iter.op.replyFunc = iter.replyFunc()
 	iter.docsToReceive++
 
+	if ae := session.encrypter(); ae != nil {
+		query, err := ae.encryptQuery(op.collection, op.query)
+		if err != nil {
+			iter.err = err
+			return iter
+		}
+		op.query = query
+	}
+
 	socket, err := session.acquireSocket(true)
 	if err != nil {
 		iter.err = err
@@ -3530,6 +4490,15 @@ func (q *Query) Tail(timeout time.Duration) *Iter {
 	iter.op.limit = op.limit
 	iter.op.replyFunc = iter.replyFunc()
 	iter.docsToReceive++
+	if ae := session.encrypter(); ae != nil {
+		query, err := ae.encryptQuery(op.collection, op.query)
+		if err != nil {
+			iter.err = err
+			return iter
+		}
+		op.query = query
+	}
+
 	session.prepareQuery(&op)
 	op.replyFunc = iter.op.replyFunc
 	op.flags |= flagTailable | flagAwaitData
@@ -3729,7 +4698,13 @@ func (iter *Iter) Next(result interface{}) bool {
 		if close {
 			iter.Close()
 		}
-		err := bson.Unmarshal(docData, result)
+		var err error
+		if ae := iter.session.encrypter(); ae != nil {
+			docData, err = ae.decryptDoc(docData)
+		}
+		if err == nil {
+			err = bson.Unmarshal(docData, result)
+		}
 		if err != nil {
 			debugf("Iter %p document unmarshaling failed: %#v", iter, err)
 			iter.m.Lock()
@@ -3965,6 +4940,10 @@ func (q *Query) Count() (n int, err error) {
 	query := op.query
 	if query == nil {
 		query = bson.D{}
+	} else if ae := session.encrypter(); ae != nil {
+		if query, err = ae.encryptQuery(op.collection, query); err != nil {
+			return 0, err
+		}
 	}
 	result := struct{ N int }{}
 	err = session.DB(dbname).Run(countCmd{cname, query, limit, op.skip}, &result)
@@ -4007,11 +4986,24 @@ func (q *Query) Distinct(key string, result interface{}) error {
 	dbname := op.collection[:c]
 	cname := op.collection[c+1:]
 
+	ae := session.encrypter()
+	if ae != nil {
+		var err error
+		if op.query, err = ae.encryptQuery(op.collection, op.query); err != nil {
+			return err
+		}
+	}
+
 	var doc struct{ Values bson.Raw }
 	err := session.DB(dbname).Run(distinctCmd{cname, key, op.query}, &doc)
 	if err != nil {
 		return err
 	}
+	if ae != nil {
+		if doc.Values.Data, err = ae.decryptDoc(doc.Values.Data); err != nil {
+			return err
+		}
+	}
 	return doc.Values.Unmarshal(result)
 }
 
@@ -4287,6 +5279,16 @@ func (q *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 	dbname := op.collection[:c]
 	cname := op.collection[c+1:]
 
+	ae := session.encrypter()
+	if ae != nil {
+		if op.query, err = ae.encryptQuery(op.collection, op.query); err != nil {
+			return nil, err
+		}
+		if change.Update, err = ae.encryptUpdate(op.collection, change.Update); err != nil {
+			return nil, err
+		}
+	}
+
 	cmd := findModifyCmd{
 		Collection: cname,
 		Update:     change.Update,
@@ -4323,6 +5325,11 @@ func (q *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 		return nil, ErrNotFound
 	}
 	if doc.Value.Kind != 0x0A && result != nil {
+		if ae != nil {
+			if doc.Value.Data, err = ae.decryptDoc(doc.Value.Data); err != nil {
+				return nil, err
+			}
+		}
 		err = doc.Value.Unmarshal(result)
 		if err != nil {
 			return nil, err
@@ -4565,20 +5572,25 @@ func (iter *Iter) replyFunc() replyFunc {
 }
 
 type writeCmdResult struct {
-	Ok        bool
-	N         int
-	NModified int `bson:"nModified"`
-	Upserted  []struct {
-		Index int
-		Id    interface{} `_id`
-	}
+	Ok           bool
+	N            int
+	NModified    int `bson:"nModified"`
+	Upserted     []writeCmdUpserted
 	ConcernError writeConcernError `bson:"writeConcernError"`
 	Errors       []writeCmdError   `bson:"writeErrors"`
 }
 
+type writeCmdUpserted struct {
+	Index int
+	Id    interface{} `_id`
+}
+
 type writeConcernError struct {
-	Code   int
-	ErrMsg string
+	Code    int
+	ErrMsg  string
+	ErrInfo struct {
+		WTimeout bool `bson:"wtimeout"`
+	} `bson:"errInfo"`
 }
 
 type writeCmdError struct {
@@ -4590,7 +5602,7 @@ type writeCmdError struct {
 func (r *writeCmdResult) BulkErrorCases() []BulkErrorCase {
 	ecases := make([]BulkErrorCase, len(r.Errors))
 	for i, err := range r.Errors {
-		ecases[i] = BulkErrorCase{err.Index, &QueryError{Code: err.Code, Message: err.ErrMsg}}
+		ecases[i] = BulkErrorCase{Index: err.Index, Err: &QueryError{Code: err.Code, Message: err.ErrMsg}}
 	}
 	return ecases
 }
@@ -4601,6 +5613,11 @@ func (r *writeCmdResult) BulkErrorCases() []BulkErrorCase {
 // will also be returned as err.
 func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err error) {
 	s := c.Database.Session
+	if ae := s.encrypter(); ae != nil {
+		if op, err = ae.encryptOp(op); err != nil {
+			return nil, err
+		}
+	}
 	socket, err := s.acquireSocket(c.Database.Name == "local")
 	if err != nil {
 		return nil, err
@@ -4614,44 +5631,20 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 
 	if socket.ServerInfo().MaxWireVersion >= 2 {
 		// Servers with a more recent write protocol benefit from write commands.
-		if op, ok := op.(*insertOp); ok && len(op.documents) > 1000 {
-			var lerr LastError
-
-			// Maximum batch size is 1000. Must split out in separate operations for compatibility.
-			all := op.documents
-			for i := 0; i < len(all); i += 1000 {
-				l := i + 1000
-				if l > len(all) {
-					l = len(all)
-				}
-				op.documents = all[i:l]
-				oplerr, err := c.writeOpCommand(socket, safeOp, op, ordered, bypassValidation)
-				lerr.N += oplerr.N
-				lerr.modified += oplerr.modified
-				if err != nil {
-					for ei := range oplerr.ecases {
-						oplerr.ecases[ei].Index += i
-					}
-					lerr.ecases = append(lerr.ecases, oplerr.ecases...)
-					if op.flags&1 == 0 {
-						return &lerr, err
-					}
-				}
-			}
-			if len(lerr.ecases) != 0 {
-				return &lerr, lerr.ecases[0].Err
-			}
-			return &lerr, nil
-		}
-		return c.writeOpCommand(socket, safeOp, op, ordered, bypassValidation)
+		return c.writeOpCommandBatches(socket, safeOp, op, ordered, bypassValidation)
 	} else if updateOps, ok := op.(bulkUpdateOp); ok {
 		var lerr LastError
 		for i, updateOp := range updateOps {
 			oplerr, err := c.writeOpQuery(socket, safeOp, updateOp, ordered)
-			lerr.N += oplerr.N
-			lerr.modified += oplerr.modified
+			if oplerr != nil {
+				lerr.N += oplerr.N
+				lerr.modified += oplerr.modified
+				if oplerr.UpsertedId != nil {
+					lerr.upserted = append(lerr.upserted, writeCmdUpserted{i, oplerr.UpsertedId})
+				}
+			}
 			if err != nil {
-				lerr.ecases = append(lerr.ecases, BulkErrorCase{i, err})
+				lerr.ecases = append(lerr.ecases, BulkErrorCase{Index: i, Err: err})
 				if ordered {
 					break
 				}
@@ -4665,10 +5658,12 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 		var lerr LastError
 		for i, deleteOp := range deleteOps {
 			oplerr, err := c.writeOpQuery(socket, safeOp, deleteOp, ordered)
-			lerr.N += oplerr.N
-			lerr.modified += oplerr.modified
+			if oplerr != nil {
+				lerr.N += oplerr.N
+				lerr.modified += oplerr.modified
+			}
 			if err != nil {
-				lerr.ecases = append(lerr.ecases, BulkErrorCase{i, err})
+				lerr.ecases = append(lerr.ecases, BulkErrorCase{Index: i, Err: err})
 				if ordered {
 					break
 				}
@@ -4682,6 +5677,154 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 	return c.writeOpQuery(socket, safeOp, op, ordered)
 }
 
+// writeOpCommandBatches delivers op using write commands, splitting the
+// documents it carries into as many commands as necessary to respect the
+// limits advertised by the server on the number of operations per batch
+// and on the size of each command. The results of the individual batches
+// are merged so that error indexes refer to positions within op.
+func (c *Collection) writeOpCommandBatches(socket *mongoSocket, safeOp *queryOp, op interface{}, ordered, bypassValidation bool) (lerr *LastError, err error) {
+	var docs []interface{}
+	var continueOnError bool
+	var batchOp func(docs []interface{}) interface{}
+	switch op := op.(type) {
+	case *insertOp:
+		docs = op.documents
+		continueOnError = op.flags&1 != 0
+		batchOp = func(docs []interface{}) interface{} {
+			return &insertOp{op.collection, docs, op.flags}
+		}
+	case bulkUpdateOp:
+		docs = op
+		continueOnError = !ordered
+		batchOp = func(docs []interface{}) interface{} { return bulkUpdateOp(docs) }
+	case bulkDeleteOp:
+		docs = op
+		continueOnError = !ordered
+		batchOp = func(docs []interface{}) interface{} { return bulkDeleteOp(docs) }
+	}
+	if len(docs) < 2 {
+		return c.writeOpCommand(socket, safeOp, op, ordered, bypassValidation)
+	}
+
+	// The documents are sent as marshalled while measuring them,
+	// so that they're not marshalled twice.
+	ends, docs, err := writeBatchEnds(socket.ServerInfo(), docs)
+	if err != nil {
+		return nil, err
+	}
+	if len(ends) == 1 {
+		return c.writeOpCommand(socket, safeOp, batchOp(docs), ordered, bypassValidation)
+	}
+
+	var merged LastError
+	var failed bool
+	start := 0
+	for _, end := range ends {
+		oplerr, operr := c.writeOpCommand(socket, safeOp, batchOp(docs[start:end]), ordered, bypassValidation)
+		if oplerr == nil && operr != nil {
+			// Not a write error, so there's no information about which
+			// operations were applied. Report all the pending ones.
+			for i := start; i < len(docs); i++ {
+				merged.ecases = append(merged.ecases, BulkErrorCase{Index: i, Err: operr})
+			}
+			return &merged, operr
+		}
+		if oplerr != nil {
+			merged.N += oplerr.N
+			merged.modified += oplerr.modified
+			if merged.UpsertedId == nil {
+				merged.UpsertedId = oplerr.UpsertedId
+			}
+			if merged.wcerr == nil {
+				merged.wcerr = oplerr.wcerr
+			}
+			for _, upserted := range oplerr.upserted {
+				upserted.Index += start
+				merged.upserted = append(merged.upserted, upserted)
+			}
+			for _, ecase := range oplerr.ecases {
+				ecase.Index += start
+				merged.ecases = append(merged.ecases, ecase)
+			}
+		}
+		// A write concern error alone doesn't mean the writes
+		// failed, so the following batches are still sent.
+		if operr != nil && len(oplerr.ecases) > 0 {
+			if !failed {
+				failed = true
+				merged.Code = oplerr.Code
+				merged.Err = oplerr.Err
+			}
+			if !continueOnError {
+				break
+			}
+		}
+		start = end
+	}
+	merged.UpdatedExisting = merged.N > 0 && merged.UpsertedId == nil
+	if !failed && merged.wcerr != nil {
+		failed = true
+		merged.Code = merged.wcerr.Code
+		merged.Err = merged.wcerr.Err
+	}
+	if failed {
+		return &merged, &merged
+	}
+	if safeOp == nil {
+		return nil, nil
+	}
+	return &merged, nil
+}
+
+// writeCmdOverhead is the room reserved within a message for the
+// write command fields surrounding the batch of documents.
+const writeCmdOverhead = 16 * 1024
+
+// writeBatchEnds returns the end offsets of the batches that docs must be
+// split into so that each write command respects both the maximum number
+// of operations per batch and the maximum command size of the server.
+// A document that alone exceeds the size limit is put in its own batch
+// so that the server may report the problem. The marshalled documents
+// are returned as well, to be sent as they are.
+func writeBatchEnds(info *mongoServerInfo, docs []interface{}) (ends []int, raws []interface{}, err error) {
+	maxCount := info.MaxWriteBatchSize
+	if maxCount == 0 {
+		maxCount = defaultMaxWriteBatchSize
+	}
+	maxSize := info.MaxBsonObjectSize
+	if maxSize == 0 {
+		maxSize = defaultMaxBsonObjectSize
+	}
+	if info.MaxMessageSizeBytes > 0 && info.MaxMessageSizeBytes-writeCmdOverhead < maxSize {
+		maxSize = info.MaxMessageSizeBytes - writeCmdOverhead
+	}
+
+	raws = make([]interface{}, len(docs))
+	var count, size int
+	for i, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		raws[i] = bson.Raw{Kind: 0x03, Data: data}
+		if count > 0 && (count == maxCount || size+batchElemSize(count, data) > maxSize) {
+			ends = append(ends, i)
+			count = 0
+			size = 0
+		}
+		size += batchElemSize(count, data)
+		count++
+	}
+	return append(ends, len(docs)), raws, nil
+}
+
+// batchElemSize returns the number of bytes taken by data when encoded
+// as the element at index i of a BSON array.
+func batchElemSize(i int, data []byte) int {
+	// Kind byte, the index as a cstring key, and the document itself.
+	return 1 + len(strconv.Itoa(i)) + 1 + len(data)
+}
+
 func (c *Collection) writeOpQuery(socket *mongoSocket, safeOp *queryOp, op interface{}, ordered bool) (lerr *LastError, err error) {
 	if safeOp == nil {
 		return nil, socket.Query(op)
@@ -4796,6 +5939,11 @@ func (c *Collection) writeOpCommand(socket *mongoSocket, safeOp *queryOp, op int
 	}
 	if len(result.Upserted) > 0 {
 		lerr.UpsertedId = result.Upserted[0].Id
+		lerr.upserted = result.Upserted
+	}
+	if result.ConcernError.Code != 0 {
+		e := result.ConcernError
+		lerr.wcerr = &LastError{Code: e.Code, Err: e.ErrMsg, WTimeout: e.ErrInfo.WTimeout}
 	}
 	if len(result.Errors) > 0 {
 		e := result.Errors[0]
@@ -908,6 +908,166 @@ func (s *S) TestCreateCollectionStorageEngine(c *C) {
 	c.Assert(err, ErrorMatches, "test is not a registered storage engine for this server")
 }
 
+func (s *S) TestCollectionRename(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	err = coll.Rename("otherdb", "othercoll", false)
+	c.Assert(err, IsNil)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	n, err = session.DB("otherdb").C("othercoll").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	err = coll.Insert(M{"n": 2})
+	c.Assert(err, IsNil)
+	err = coll.Rename("otherdb", "othercoll", false)
+	c.Assert(err, ErrorMatches, "target namespace exists")
+	err = coll.Rename("otherdb", "othercoll", true)
+	c.Assert(err, IsNil)
+
+	var result M
+	err = session.DB("otherdb").C("othercoll").Find(nil).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result["n"], Equals, 2)
+}
+
+func (s *S) TestCollectionModify(c *C) {
+	if !s.versionAtLeast(3, 2) {
+		c.Skip("validation depends on MongoDB 3.2+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"a": 1})
+	c.Assert(err, IsNil)
+
+	err = coll.Modify(&mgo.CollectionChange{
+		Validator:       M{"b": M{"$exists": true}},
+		ValidationLevel: "moderate",
+	})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"a": 2})
+	c.Assert(err, ErrorMatches, "Document failed validation")
+
+	err = coll.Modify(&mgo.CollectionChange{ValidationAction: "warn"})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"a": 2})
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestCreateView(c *C) {
+	if !s.versionAtLeast(3, 4) {
+		c.Skip("views depend on MongoDB 3.4+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	err = db.C("mycoll").Insert(M{"n": 1, "active": true}, M{"n": 2, "active": false})
+	c.Assert(err, IsNil)
+
+	pipeline := []M{{"$match": M{"active": true}}}
+	err = db.CreateView("myview", "mycoll", pipeline, &mgo.Collation{Locale: "en"})
+	c.Assert(err, IsNil)
+
+	var result []M
+	err = db.C("myview").Find(nil).Select(M{"_id": 0, "n": 1}).All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, []M{{"n": 1}})
+
+	err = db.C("myview").Insert(M{"n": 3})
+	c.Assert(err, NotNil)
+
+	specs, err := db.ListCollections(M{"type": "view"})
+	c.Assert(err, IsNil)
+	c.Assert(specs, HasLen, 1)
+	c.Assert(specs[0].Name, Equals, "myview")
+	c.Assert(specs[0].ReadOnly, Equals, true)
+	c.Assert(specs[0].ViewOn, Equals, "mycoll")
+	c.Assert(specs[0].Pipeline, HasLen, 1)
+}
+
+func (s *S) TestCreateCollectionTimeSeries(c *C) {
+	if !s.versionAtLeast(5, 0) {
+		c.Skip("time-series collections depend on MongoDB 5.0+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	info := &mgo.CollectionInfo{
+		TimeSeries: &mgo.TimeSeriesInfo{
+			TimeField:   "ts",
+			MetaField:   "sensor",
+			Granularity: "minutes",
+		},
+		ExpireAfter: 24 * time.Hour,
+	}
+	err = db.C("measurements").Create(info)
+	c.Assert(err, IsNil)
+
+	err = db.C("measurements").Insert(M{"ts": time.Now(), "sensor": "a", "value": 1})
+	c.Assert(err, IsNil)
+
+	specs, err := db.ListCollections(M{"name": "measurements"})
+	c.Assert(err, IsNil)
+	c.Assert(specs, HasLen, 1)
+	c.Assert(specs[0].Type, Equals, "timeseries")
+	c.Assert(specs[0].TimeSeries, NotNil)
+	c.Assert(specs[0].TimeSeries.TimeField, Equals, "ts")
+	c.Assert(specs[0].TimeSeries.MetaField, Equals, "sensor")
+	c.Assert(specs[0].TimeSeries.Granularity, Equals, "minutes")
+
+	err = db.C("measurements").Create(&mgo.CollectionInfo{TimeSeries: &mgo.TimeSeriesInfo{}})
+	c.Assert(err, ErrorMatches, "Collection.Create: with TimeSeries, TimeField must also be set")
+}
+
+func (s *S) TestListCollections(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	err = db.C("plain").Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+	err = db.C("capped").Create(&mgo.CollectionInfo{Capped: true, MaxBytes: 4096, MaxDocs: 3})
+	c.Assert(err, IsNil)
+
+	specs, err := db.ListCollections(nil)
+	c.Assert(err, IsNil)
+
+	byName := make(map[string]mgo.CollectionSpec)
+	for _, spec := range specs {
+		byName[spec.Name] = spec
+	}
+	c.Assert(byName["plain"].Type, Equals, "collection")
+	c.Assert(byName["plain"].Capped, Equals, false)
+	c.Assert(byName["capped"].Type, Equals, "collection")
+	c.Assert(byName["capped"].Capped, Equals, true)
+	c.Assert(byName["capped"].MaxDocs, Equals, 3)
+	c.Assert(byName["capped"].Options["capped"], Equals, true)
+
+	if s.versionAtLeast(3, 0) {
+		specs, err = db.ListCollections(M{"name": "plain"})
+		c.Assert(err, IsNil)
+		c.Assert(specs, HasLen, 1)
+		c.Assert(specs[0].Name, Equals, "plain")
+	}
+}
+
 func (s *S) TestIsDupValues(c *C) {
 	c.Assert(mgo.IsDup(nil), Equals, false)
 	c.Assert(mgo.IsDup(&mgo.LastError{Code: 1}), Equals, false)
@@ -3402,6 +3562,137 @@ func (s *S) TestEnsureIndexExpireAfter(c *C) {
 	}
 }
 
+func (s *S) TestEnsureIndexes(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.EnsureIndexKey("a")
+	c.Assert(err, IsNil)
+
+	mgo.ResetStats()
+
+	err = coll.EnsureIndexes(
+		mgo.Index{Key: []string{"a"}},
+		mgo.Index{Key: []string{"-b"}},
+		mgo.Index{Key: []string{"c"}, Unique: true},
+	)
+	c.Assert(err, IsNil)
+
+	// The cached index is skipped and the others are created at once.
+	stats := mgo.GetStats()
+	if s.versionAtLeast(2, 6) {
+		c.Assert(stats.SentOps, Equals, 1)
+	}
+
+	indexes, err := coll.Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes, HasLen, 4)
+	c.Assert(indexes[1].Name, Equals, "a_1")
+	c.Assert(indexes[2].Name, Equals, "b_-1")
+	c.Assert(indexes[3].Name, Equals, "c_1")
+	c.Assert(indexes[3].Unique, Equals, true)
+
+	mgo.ResetStats()
+
+	err = coll.EnsureIndexes(mgo.Index{Key: []string{"-b"}}, mgo.Index{Key: []string{"c"}, Unique: true})
+	c.Assert(err, IsNil)
+
+	stats = mgo.GetStats()
+	c.Assert(stats.SentOps, Equals, 0)
+}
+
+func (s *S) TestEnsureIndexPartialFilter(c *C) {
+	if !s.versionAtLeast(3, 2) {
+		c.Skip("partial indexes require 3.2+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	index := mgo.Index{
+		Key:           []string{"a"},
+		Unique:        true,
+		PartialFilter: bson.M{"b": bson.M{"$gt": 10}},
+	}
+	err = coll.EnsureIndex(index)
+	c.Assert(err, IsNil)
+
+	// Documents outside of the filter are not constrained.
+	err = coll.Insert(M{"a": 1, "b": 1}, M{"a": 1, "b": 2})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"a": 1, "b": 11}, M{"a": 1, "b": 12})
+	c.Assert(mgo.IsDup(err), Equals, true)
+
+	indexes, err := coll.Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes[1].Name, Equals, "a_1")
+	c.Assert(indexes[1].PartialFilter, DeepEquals, bson.M{"b": bson.M{"$gt": 10}})
+}
+
+func (s *S) TestEnsureIndexWildcard(c *C) {
+	if !s.versionAtLeast(4, 2) {
+		c.Skip("wildcard indexes require 4.2+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	index := mgo.Index{
+		Key:                []string{"$**"},
+		WildcardProjection: bson.M{"a": 1},
+	}
+	err = coll.EnsureIndex(index)
+	c.Assert(err, IsNil)
+
+	indexes, err := coll.Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes[0].Name, Equals, "$**_1")
+	c.Assert(indexes[0].Key, DeepEquals, []string{"$**"})
+	c.Assert(indexes[0].WildcardProjection, DeepEquals, bson.M{"a": 1})
+}
+
+func (s *S) TestModifyIndex(c *C) {
+	if !s.versionAtLeast(4, 4) {
+		c.Skip("hidden indexes require 4.4+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"t"}, ExpireAfter: time.Minute, Hidden: true})
+	c.Assert(err, IsNil)
+
+	indexes, err := coll.Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes[1].Name, Equals, "t_1")
+	c.Assert(indexes[1].Hidden, Equals, true)
+
+	hidden := false
+	expireAfter := time.Hour
+	err = coll.ModifyIndex("t_1", mgo.IndexChange{Hidden: &hidden, ExpireAfter: &expireAfter})
+	c.Assert(err, IsNil)
+
+	indexes, err = coll.Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes[1].Hidden, Equals, false)
+	c.Assert(indexes[1].ExpireAfter, Equals, time.Hour)
+
+	err = coll.ModifyIndex("missing_1", mgo.IndexChange{Hidden: &hidden})
+	c.Assert(err, NotNil)
+
+	err = coll.ModifyIndex("t_1", mgo.IndexChange{})
+	c.Assert(err, ErrorMatches, "no changes provided for index t_1")
+}
+
 func (s *S) TestDistinct(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -4110,6 +4401,97 @@ func (s *S) TestNewIterNoServerPresetErr(c *C) {
 	c.Assert(iter.Err(), ErrorMatches, "my error")
 }
 
+func (s *S) TestIterState(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 10; i++ {
+		err = coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	iter := coll.Find(nil).Sort("$natural").Batch(2).Limit(8).Iter()
+	var result struct{ N int }
+	for i := 0; i < 3; i++ {
+		c.Assert(iter.Next(&result), Equals, true)
+		c.Assert(result.N, Equals, i)
+	}
+	state, err := iter.State()
+	c.Assert(err, IsNil)
+	c.Assert(state.CursorId, Not(Equals), int64(0))
+	c.Assert(state.Namespace, Equals, "mydb.mycoll")
+	c.Assert(state.Server, Equals, "localhost:40001")
+	c.Assert(state.Limit, Equals, int32(5))
+
+	// The original iterator no longer owns the cursor.
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Close(), IsNil)
+
+	// Round-trip the state as another process would.
+	data, err := bson.Marshal(state)
+	c.Assert(err, IsNil)
+	var loaded mgo.IterState
+	err = bson.Unmarshal(data, &loaded)
+	c.Assert(err, IsNil)
+
+	other, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer other.Close()
+
+	iter = other.DB("mydb").C("mycoll").NewIterFromState(&loaded)
+	for i := 3; i < 8; i++ {
+		c.Assert(iter.Next(&result), Equals, true)
+		c.Assert(result.N, Equals, i)
+	}
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Close(), IsNil)
+}
+
+func (s *S) TestIterStateClose(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 10; i++ {
+		err = coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	iter := coll.Find(nil).Batch(2).Iter()
+	var result struct{ N int }
+	c.Assert(iter.Next(&result), Equals, true)
+	state, err := iter.State()
+	c.Assert(err, IsNil)
+
+	iter = coll.NewIterFromState(state)
+	c.Assert(iter.Next(&result), Equals, true)
+	c.Assert(iter.Close(), IsNil)
+
+	// The cursor was killed by the resumed iterator.
+	iter = coll.NewIterFromState(state)
+	for iter.Next(&result) {
+	}
+	c.Assert(iter.Close(), NotNil)
+}
+
+func (s *S) TestNewIterFromStateUnknownServer(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	session.SetSyncTimeout(500 * time.Millisecond)
+
+	coll := session.DB("mydb").C("mycoll")
+	iter := coll.NewIterFromState(&mgo.IterState{CursorId: 42, Namespace: "mydb.mycoll", Server: "localhost:1"})
+
+	var result struct{ N int }
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Err(), ErrorMatches, "server localhost:1 is not reachable")
+}
+
 func (s *S) TestBypassValidation(c *C) {
 	if !s.versionAtLeast(3, 2) {
 		c.Skip("validation supported on 3.2+")
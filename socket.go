@@ -79,6 +79,8 @@ type queryOp struct {
 	options    queryWrapper
 	hasOptions bool
 	serverTags []bson.D
+
+	explainVerbosity string
 }
 
 type queryWrapper struct {
@@ -311,9 +313,11 @@ func (socket *mongoSocket) updateDeadline(which deadlineType) {
 	debugf("Socket %p to %s: updated %s deadline to %s ahead (%s)", socket, socket.addr, whichstr, socket.timeout, when)
 }
 
+var errSocketClosed = errors.New("Closed explicitly")
+
 // Close terminates the socket use.
 func (socket *mongoSocket) Close() {
-	socket.kill(errors.New("Closed explicitly"), false)
+	socket.kill(errSocketClosed, false)
 }
 
 func (socket *mongoSocket) kill(err error, abend bool) {